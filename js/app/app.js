@@ -1218,7 +1218,7 @@ App.prototype._populateGallery = function() {
         populating: false,
         table: table,
         emptyCells: cells,
-        pages: 0,
+        cursor: null,
         pageSize: pageSize,
         pagePerBatch: pagePerBatch,
         batchSize: batchSize,
@@ -1262,16 +1262,22 @@ App.prototype._populateGallery = function() {
 
         state.populating = true;
 
-        // Ok, populate all the empty cells
-        utils.getQuery('g', {
-            page: state.pages,
-            limit: state.batchSize
-        }, {
-            success: (function(req, ret) {
+        // Ok, populate all the empty cells. An empty cursor starts the
+        // listing from the beginning
+        var query = {
+            limit: state.batchSize,
+            cursor: state.cursor || ''
+        };
+
+        utils.getQuery('g', query, {
+            success: (function(req, listing) {
                 if (this._mode !== 'gallery') {
                     return;
                 }
 
+                var ret = listing.items;
+                state.cursor = listing.next || null;
+
                 // Fill up to 'ret' empty cells
                 for (var i = 0; i < ret.length; i++) {
                     this._fillGalleryItem(state.emptyCells[i], ret[i]);
@@ -1280,7 +1286,7 @@ App.prototype._populateGallery = function() {
 
                 state.populating = false;
 
-                if (ret.length < state.batchSize) {
+                if (state.cursor === null) {
                     var n = Math.ceil(ret.length / state.nColumns) * state.nColumns;
                     state.emptyCells = state.emptyCells.slice(n);
 
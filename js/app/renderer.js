@@ -29,168 +29,7 @@
 
 var Signals = require('../signals/signals');
 var utils = require('../utils/utils');
-
-/**
- * The javascript context.
- *
- * @constructor
- */
-function JsContext(gl) {
-    /**
-     * The webgl context. This is the WebGLRenderingContext obtained
-     * from the canvas.
-     */
-    this.gl = gl;
-
-    /**
-     * The models module. This module contains various high-level
-     * utilities for creating and working with object models.
-     */
-    this.models = require('../models/models');
-
-    /**
-     * The math module. This module contains basic math types, including
-     * vectors, matrices, quaternions and transforms. Note that this
-     * module is provided by glMatrix, with a small number of additional
-     * types and functions (such as transform).
-     */
-    this.math = require('../math/math');
-
-    this.ui = require('./ui');
-
-    /**
-     * The shared program.
-     */
-    this.program = {};
-
-    /**
-     * A map of program names to compiled GLSL programs.
-     */
-    this.programs = {};
-    this._defaultProgram = null;
-
-    /**
-     * A persistent state. You can use this to store and retrieve persistent state
-     * between recompilations of your program.
-     */
-    this.state = {};
-
-    this._signals = new Signals();
-    this._signals.onEvent = this._signals.registerSignal('event');
-
-    this._view = null;
-    this._defines = {};
-
-    this._signals.onDefine = this._signals.registerSignal('define');
-}
-
-/**
- * Get/set the current rendering view.
- *
- * When called without parameters, obtains the current rendering view,
- * otherwise sets it. The view is a model with an associated projection
- * and viewport. See {@link models.View} for more information on
- * constructing a view. All model rendering after setting a view will
- * use that views information to render.
- *
- * @param view the new view to set, or not provided to obtain the current view.
- */
-JsContext.prototype.view = function(view) {
-    if (typeof view === 'undefined') {
-        return this._view;
-    }
-
-    if (this._view !== null) {
-        this._view.unbind(this);
-    }
-
-    this._view = view;
-    view.updateViewport(this);
-
-    view.bind(this);
-};
-
-JsContext.prototype.define = function(program, name, value) {
-    if (!(program in this._defines)) {
-        this._defines[program] = {};
-    }
-
-    this._defines[program][name] = value;
-    this._signals.onDefine(program);
-};
-
-JsContext.prototype.defines = function(program, defines) {
-    if (!(program in this._defines)) {
-        this._defines[program] = {};
-    }
-
-    var prg = this._defines[program];
-
-    for (var k in defines) {
-        prg[k] = defines[k];
-    }
-
-    this._signals.onDefine(program);
-};
-
-JsContext.prototype.requireExtension = function(ext) {
-    var e = this.gl.getExtension(ext);
-
-    if (!e) {
-        throw new Error('Missing required extension ' + ext);
-    }
-
-    return e;
-};
-
-JsContext.prototype.requireExtensions = function(exts) {
-    var ret = {};
-
-    for (var i = 0; i < exts.length; i++) {
-        var e = this.requireExtension(exts[i]);
-
-        ret[exts[i]] = e;
-    }
-
-    return ret;
-};
-
-JsContext.prototype.getExtension = function(ext) {
-    return this.gl.getExtension(ext);
-};
-
-JsContext.prototype.getExtensions = function(exts) {
-    var ret = {};
-
-    for (var i = 0; i < exts.length; i++) {
-        var e = this.gl.getExtension(exts[i]);
-
-        if (e) {
-            ret[exts[i]] = e;
-        }
-    }
-
-    return ret;
-};
-
-/**
- * Find a GLSL program by name. If the name is not given, or null, then
- * the default program will be returned.
- *
- * @param name the program name.
- * @returns a program object.
- */
-JsContext.prototype.findProgram = function(name) {
-    if (!name) {
-        return this._defaultProgram;
-    }
-
-    if (!(name in this.programs)) {
-        return null;
-    }
-
-    return this.programs[name];
-};
+var JsContext = require('./context');
 
 function Renderer(canvas, fullscreenParent, options) {
     Signals.call(this);
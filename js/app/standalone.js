@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+var JsContext = require('./context');
+var Program = require('./program');
+
+/**
+ * Runs a document outside of the playground, as in pages exported by the
+ * server. The document is in its remote (JSON) format, and assets maps the
+ * names of its assets to image URLs.
+ *
+ * @constructor
+ */
+function Standalone(canvas, doc, assets, onError) {
+    this.canvas = canvas;
+    this.doc = doc;
+    this.assets = assets || {};
+    this.program = null;
+
+    this._onError = onError || function(e) {
+        console.error(e);
+    };
+
+    this._programs = {};
+
+    for (var i = 0; i < doc.programs.length; i++) {
+        var p = Program.fromRemote(doc.programs[i]);
+        this._programs[p.name()] = p;
+    }
+
+    var gl = canvas.getContext('webgl', {preserveDrawingBuffer: true}) ||
+             canvas.getContext('experimental-webgl', {preserveDrawingBuffer: true});
+
+    if (!gl) {
+        this._onError('WebGL is not supported by your browser');
+        return;
+    }
+
+    this.context = new JsContext(gl);
+    this.context.assets = {};
+    this.context.ui.add = this._uiAdd.bind(this);
+
+    this.context._signals.on('define', (function(_, name) {
+        this._compile(name);
+    }).bind(this));
+
+    var events = ['mousedown', 'mouseup', 'mousemove', 'keydown', 'keyup', 'keypress', 'wheel'];
+
+    for (i = 0; i < events.length; i++) {
+        canvas.addEventListener(events[i], this._onEvent.bind(this));
+    }
+
+    window.addEventListener('resize', this._resize.bind(this));
+    this._resize();
+
+    this._loadAssets(this._start.bind(this));
+}
+
+Standalone.prototype._resize = function() {
+    var ratio = window.devicePixelRatio || 1;
+
+    this.canvas.width = Math.round(this.canvas.clientWidth * ratio);
+    this.canvas.height = Math.round(this.canvas.clientHeight * ratio);
+
+    if (this.context.view()) {
+        this.context.view().updateViewport(this.context);
+    }
+};
+
+Standalone.prototype._uiAdd = function(ui, placement) {
+    this.canvas.parentElement.appendChild(ui.e);
+
+    if (placement) {
+        for (var p in placement) {
+            var v = placement[p];
+
+            if (typeof v === 'number') {
+                v += 'px';
+            }
+
+            ui.e.style[p] = v;
+        }
+    }
+
+    return this._extractUiIds(ui, '', {});
+};
+
+Standalone.prototype._extractUiIds = function(ui, prefix, ret) {
+    if (typeof ui._settings.id !== 'undefined') {
+        if (prefix) {
+            prefix += '.' + ui._settings.id;
+        } else {
+            prefix = ui._settings.id;
+        }
+
+        ret[prefix] = ui;
+    }
+
+    for (var i = 0; i < ui.children.length; i++) {
+        this._extractUiIds(ui.children[i], prefix, ret);
+    }
+
+    return ret;
+};
+
+// Assets are loaded before the document is started, so that they can be used
+// in init
+Standalone.prototype._loadAssets = function(cb) {
+    var names = Object.keys(this.assets);
+    var pending = names.length;
+
+    if (pending === 0) {
+        cb();
+        return;
+    }
+
+    names.forEach(function(name) {
+        var img = new Image();
+
+        img.onload = function() {
+            pending--;
+
+            if (pending === 0) {
+                cb();
+            }
+        };
+
+        img.onerror = (function() {
+            this._onError('Failed to load asset ' + name);
+        }).bind(this);
+
+        img.src = this.assets[name];
+        this.context.assets[name] = img;
+    }, this);
+};
+
+Standalone.prototype._compile = function(name) {
+    var ctx = this.context;
+    var prog = this._programs[name].compile(ctx.gl, ctx._defines[name]);
+
+    var errors = [prog.vertex.error, prog.fragment.error, prog.error].filter(function(e) {
+        return e !== null;
+    });
+
+    if (errors.length !== 0) {
+        throw new Error(name + ': ' + errors.join('\n'));
+    }
+
+    ctx.programs[name] = prog;
+
+    if (prog.isDefault) {
+        ctx._defaultProgram = prog;
+    }
+};
+
+Standalone.prototype._start = function() {
+    var ctx = this.context;
+
+    try {
+        // jshint ignore:start
+        this.program = new Function(this.doc.javascript
+                                    + '\n\nreturn {init: typeof init !== "undefined" ? init : null'
+                                    + ', render: typeof render !== "undefined" ? render : null'
+                                    + ', event: typeof event !== "undefined" ? event : null'
+                                    + ', extensions: typeof extensions !== "undefined" ? extensions : null};').call({});
+        // jshint ignore:end
+
+        if (this.program.extensions) {
+            this.program.extensions.call(this.program, ctx);
+        }
+
+        for (var name in this._programs) {
+            this._compile(name);
+        }
+
+        if (this.program.init) {
+            this.program.init.call(this.program, ctx);
+        }
+    } catch (e) {
+        this._onError(e.message);
+        this.program = null;
+        return;
+    }
+
+    if (this.program.render) {
+        requestAnimationFrame(this._render.bind(this));
+    }
+};
+
+Standalone.prototype._render = function() {
+    try {
+        this.program.render.call(this.program, this.context);
+    } catch (e) {
+        this._onError(e.message);
+        return;
+    }
+
+    requestAnimationFrame(this._render.bind(this));
+};
+
+Standalone.prototype._onEvent = function(e) {
+    if (this.program === null) {
+        return;
+    }
+
+    if (typeof this.program.event === 'function') {
+        try {
+            this.program.event.call(this.program, this.context, e);
+        } catch (err) {
+            this._onError(err.message);
+        }
+    }
+
+    if (!e.defaultPrevented) {
+        this.context._signals.onEvent(e);
+    }
+};
+
+module.exports = Standalone;
+
+// vi:ts=4:et
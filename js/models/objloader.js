@@ -39,7 +39,7 @@ var objectCache = {};
 var fs = require('fs');
 
 function WavefrontParser() {
-    if (utils.Browser.IsIE) {
+    if (utils.Browser.IsIE && global.Settings.frontend) {
         this._worker = new Worker(global.Settings.frontend.url('assets/js/models/wavefrontparser.js'));
     } else {
         var code = new Blob([fs.readFileSync(__dirname + '/wavefrontparser.js', 'utf-8')],
@@ -193,7 +193,10 @@ exports.load = function(ctx, filename, options) {
     var localPrefix = 'local:';
     var isLocal = (filename.indexOf(localPrefix) === 0);
 
-    if (!isLocal && document.location.protocol.indexOf('file') === 0) {
+    // Pages exported by the server embed the built-in models they use
+    var embedded = global.Settings.models;
+
+    if (!isLocal && !embedded && document.location.protocol.indexOf('file') === 0) {
         throw new Error('Cannot load external models in local mode');
     }
 
@@ -227,7 +230,34 @@ exports.load = function(ctx, filename, options) {
         fromCache = ret.children.slice(0);
     }
 
-    if (isLocal) {
+    if (embedded) {
+        if (!embedded.hasOwnProperty(filename)) {
+            options.error('Model ' + filename + ' is not embedded in the page');
+            return ret;
+        }
+
+        if (cached) {
+            options.success(ret);
+            return ret;
+        }
+
+        var parseOptions = {
+            autosmooth: options.autosmooth,
+            shareVertices: options.shareVertices
+        };
+
+        wavefrontParser.parse(embedded[filename], parseOptions, function(objects) {
+            objectCache[cacheKey(filename, options)] = {
+                date: new Date(0),
+                objects: objects
+            };
+
+            createModel(ctx, ret, objects, options);
+
+            options.complete(ret);
+            options.success(ret);
+        });
+    } else if (isLocal) {
         new Store(function(store) {
             var localName = filename.slice(localPrefix.length);
 
@@ -78,6 +78,31 @@ function htmlEscape(s) {
     return escapeDiv.innerHTML;
 }
 
+// apiError returns the error reported by the server in the response of a
+// failed request, or null if the response does not contain one
+function apiError(req) {
+    var ret;
+
+    try {
+        ret = JSON.parse(req.responseText);
+    } catch (err) {
+        return null;
+    }
+
+    if (!ret || !ret.error || typeof ret.error.message !== 'string') {
+        return null;
+    }
+
+    var err = new Error(ret.error.message);
+
+    err.code = ret.error.code;
+    err.status = ret.error.status;
+    err.field = ret.error.field;
+    err.details = ret.error.details;
+
+    return err;
+}
+
 function api(url, method, data, options) {
     options = merge({
         success: function() {},
@@ -107,7 +132,7 @@ function api(url, method, data, options) {
 
             options.success(req, ret);
         } else {
-            options.error(req, null);
+            options.error(req, apiError(req));
         }
     };
 
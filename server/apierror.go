@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Codes of API errors. Codes are stable, so that clients can act on them
+// rather than on the message.
+const (
+	ErrorInvalidRequest      = "invalid_request"
+	ErrorNotFound            = "not_found"
+	ErrorInternal            = "internal_error"
+	ErrorMissingField        = "missing_field"
+	ErrorInvalidField        = "invalid_field"
+	ErrorInvalidLicense      = "invalid_license"
+	ErrorAuthorRequired      = "author_required"
+	ErrorIncompatibleLicense = "incompatible_license"
+	ErrorShader              = "shader_error"
+	ErrorParentNotFound      = "parent_not_found"
+	ErrorInvalidToken        = "invalid_token"
+	ErrorInvalidScreenshot   = "invalid_screenshot"
+	ErrorInvalidArchive      = "invalid_archive"
+	ErrorInvalidAsset        = "invalid_asset"
+	ErrorAssetTooLarge       = "asset_too_large"
+	ErrorAssetNotFound       = "asset_not_found"
+	ErrorExportUnavailable   = "export_unavailable"
+)
+
+// APIError is an error which is reported to clients. Field is the path of
+// the offending field in the request (e.g. document.programs[0].vertex), and
+// Details holds additional information depending on the code.
+type APIError struct {
+	Code    string      `json:"code"`
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Field   string      `json:"field,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// InvalidError returns an error for a request with invalid content
+func InvalidError(code string, field string, format string, args ...interface{}) *APIError {
+	return &APIError{
+		Code:    code,
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf(format, args...),
+		Field:   field,
+	}
+}
+
+// MissingError returns an error for a request without a required field
+func MissingError(field string, format string, args ...interface{}) *APIError {
+	return InvalidError(ErrorMissingField, field, format, args...)
+}
+
+// NotFoundError returns an error for a resource which does not exist
+func NotFoundError() *APIError {
+	return &APIError{
+		Code:    ErrorNotFound,
+		Status:  http.StatusNotFound,
+		Message: "404 not found",
+	}
+}
+
+// nestError places the field of an API error under the given path
+func nestError(err error, path string) error {
+	aerr, ok := err.(*APIError)
+
+	if !ok {
+		return err
+	}
+
+	nested := *aerr
+
+	if len(nested.Field) != 0 {
+		nested.Field = path + "." + nested.Field
+	} else {
+		nested.Field = path
+	}
+
+	return &nested
+}
+
+// AsAPIError converts any error to the API error reported to clients. Errors
+// of missing data are reported as not found, and errors without a more
+// specific API error as internal errors.
+func AsAPIError(err error) *APIError {
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case *LicenseError:
+		return &APIError{
+			Code:    ErrorIncompatibleLicense,
+			Status:  http.StatusBadRequest,
+			Message: e.Error(),
+			Field:   "license",
+			Details: e,
+		}
+	}
+
+	if os.IsNotExist(err) {
+		return NotFoundError()
+	}
+
+	return &APIError{
+		Code:    ErrorInternal,
+		Status:  http.StatusInternalServerError,
+		Message: err.Error(),
+	}
+}
+
+// RespondError responds with err as a JSON error
+func RespondError(writer http.ResponseWriter, err error) {
+	aerr := AsAPIError(err)
+
+	if aerr.Status >= http.StatusInternalServerError {
+		log.Printf("Internal error: %v", err)
+	}
+
+	h := writer.Header()
+
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	writer.WriteHeader(aerr.Status)
+
+	if err := json.NewEncoder(writer).Encode(errorResponse{Error: aerr}); err != nil {
+		log.Printf("Failed to encode json response: %v", err)
+	}
+}
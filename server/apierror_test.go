@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAsAPIError(t *testing.T) {
+	invalid := InvalidError(ErrorInvalidField, "title", "Invalid title")
+
+	tests := []struct {
+		err    error
+		code   string
+		status int
+		field  string
+	}{
+		{invalid, ErrorInvalidField, http.StatusBadRequest, "title"},
+		{MissingError("name", "Missing name"), ErrorMissingField, http.StatusBadRequest, "name"},
+		{NotFoundError(), ErrorNotFound, http.StatusNotFound, ""},
+		{&LicenseError{Author: Author{License: "CC BY"}, Earlier: Author{License: "CC BY-SA"}, Term: "ShareAlike"}, ErrorIncompatibleLicense, http.StatusBadRequest, "license"},
+		{os.ErrNotExist, ErrorNotFound, http.StatusNotFound, ""},
+		{errors.New("disk on fire"), ErrorInternal, http.StatusInternalServerError, ""},
+	}
+
+	for _, test := range tests {
+		aerr := AsAPIError(test.err)
+
+		if aerr.Code != test.code || aerr.Status != test.status || aerr.Field != test.field {
+			t.Errorf("%v: expected %s (%d) for %q, but got %s (%d) for %q", test.err, test.code, test.status, test.field, aerr.Code, aerr.Status, aerr.Field)
+		}
+	}
+
+	if AsAPIError(invalid) != invalid {
+		t.Errorf("expected API errors to be returned as is")
+	}
+}
+
+func TestNestError(t *testing.T) {
+	tests := []struct {
+		err      error
+		path     string
+		expected string
+	}{
+		{MissingError("name", "Missing name"), "document.programs[1]", "document.programs[1].name"},
+		{MissingError("", "Missing program"), "document.programs[1]", "document.programs[1]"},
+	}
+
+	for _, test := range tests {
+		nested, ok := nestError(test.err, test.path).(*APIError)
+
+		if !ok || nested.Field != test.expected {
+			t.Errorf("expected field %s, but got %+v", test.expected, nested)
+		}
+
+		// The original error is not modified
+		if test.err.(*APIError).Field == test.expected {
+			t.Errorf("expected %s not to be modified", test.expected)
+		}
+	}
+
+	err := errors.New("other")
+
+	if nestError(err, "document") != err {
+		t.Errorf("expected errors other than API errors to be returned as is")
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	RespondError(w, nestError(InvalidError(ErrorShader, "vertex", "Shader does not compile"), "document.programs[0]"))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, but got %d", http.StatusBadRequest, w.Code)
+	}
+
+	for k, v := range map[string]string{"Content-Type": "application/json", "X-Content-Type-Options": "nosniff"} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("expected %s %s, but got %s", k, v, got)
+		}
+	}
+
+	var resp struct {
+		Error map[string]interface{} `json:"error"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"code":    ErrorShader,
+		"status":  float64(http.StatusBadRequest),
+		"message": "Shader does not compile",
+		"field":   "document.programs[0].vertex",
+	}
+
+	if len(resp.Error) != len(expected) {
+		t.Errorf("expected %v, but got %v", expected, resp.Error)
+	}
+
+	for k, v := range expected {
+		if resp.Error[k] != v {
+			t.Errorf("expected %s %v, but got %v", k, v, resp.Error[k])
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	setupTest(t)
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		status int
+		code   string
+	}{
+		{"GET", "/d/0000.json", "", http.StatusNotFound, ErrorNotFound},
+		{"PATCH", "/d/new", "", http.StatusNotFound, ErrorNotFound},
+		{"POST", "/d/new", "{", http.StatusBadRequest, ErrorInvalidRequest},
+		{"POST", "/d/new", `{"document": {"title": "Test"}, "license": "CC BY"}`, http.StatusBadRequest, ErrorMissingField},
+		{"POST", "/d/new", `{"document": {"title": "Test", "programs": [{"name": "default", "isDefault": true, "vertex": "void main() {}", "fragment": "void main() {}"}]}, "license": "GPL"}`, http.StatusBadRequest, ErrorInvalidLicense},
+	}
+
+	for _, test := range tests {
+		w := serveTest(t, test.method, test.url, nil, strings.NewReader(test.body))
+
+		var resp errorResponse
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == nil {
+			t.Errorf("%s %s: expected error envelope, but got %s", test.method, test.url, w.Body.String())
+			continue
+		}
+
+		if w.Code != test.status || resp.Error.Code != test.code || resp.Error.Status != test.status {
+			t.Errorf("%s %s: expected %s (%d), but got %d: %s", test.method, test.url, test.code, test.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,458 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxArchiveFileSize limits the size of the files extracted from uploaded
+// archives
+const maxArchiveFileSize = 1 << 21
+
+type ArchiveHandler struct {
+	RestishVoid
+}
+
+type ImportArchiveHandler struct {
+	RestishVoid
+}
+
+// ArchiveProgram describes a program in the metadata of an archive. Vertex
+// and Fragment are the paths of the shader files in the archive.
+type ArchiveProgram struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	IsDefault bool   `json:"isDefault"`
+	Vertex    string `json:"vertex"`
+	Fragment  string `json:"fragment"`
+}
+
+// ArchiveAsset describes an asset in the metadata of an archive. Path is the
+// path of the image file in the archive.
+type ArchiveAsset struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// ArchiveMetadata contains everything of a document which is not stored in
+// separate files of an archive
+type ArchiveMetadata struct {
+	Version      int              `json:"version"`
+	Title        string           `json:"title"`
+	Description  string           `json:"description"`
+	Programs     []ArchiveProgram `json:"programs"`
+	CreationTime time.Time        `json:"creationTime"`
+	Authors      []Author         `json:"authors"`
+	Parent       string           `json:"parent,omitempty"`
+	Assets       []ArchiveAsset   `json:"assets,omitempty"`
+}
+
+// archiveFileName makes a program name safe to use as a file name
+func archiveFileName(name string) string {
+	ret := []byte(name)
+
+	for i, c := range ret {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_' || (c == '.' && i != 0):
+		default:
+			ret[i] = '_'
+		}
+	}
+
+	if len(ret) == 0 {
+		return "_"
+	}
+
+	return string(ret)
+}
+
+// archiveLicense returns the contents of the LICENSE file of an archive of
+// the document found at the given source address
+func archiveLicense(doc *Document, source string) (string, error) {
+	attribution, err := NewAttribution(doc, source)
+
+	if err != nil {
+		return "", err
+	}
+
+	return attribution.Text, nil
+}
+
+func archiveHasFile(files map[string]string, name string) bool {
+	_, ok := files[name]
+	return ok
+}
+
+// WriteArchive writes a document as a zip archive, with every shader and the
+// javascript in a separate file. The source is the address of the document,
+// which is credited in the LICENSE file.
+func WriteArchive(w io.Writer, doc *Document, source string) error {
+	meta := ArchiveMetadata{
+		Version:      doc.Version,
+		Title:        doc.Title,
+		Description:  doc.Description,
+		Programs:     make([]ArchiveProgram, len(doc.Programs)),
+		CreationTime: doc.CreationTime,
+		Authors:      doc.Authors,
+		Parent:       doc.Parent,
+	}
+
+	files := make(map[string]string)
+	var order []string
+
+	add := func(name string, data string) {
+		files[name] = data
+		order = append(order, name)
+	}
+
+	for i, p := range doc.Programs {
+		base := "programs/" + archiveFileName(p.Name)
+		name := base
+
+		// Different program names can map to the same file name
+		for n := 2; archiveHasFile(files, name+".glslv") || archiveHasFile(files, name+".glslf"); n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		meta.Programs[i] = ArchiveProgram{
+			Version:   p.Version,
+			Name:      p.Name,
+			IsDefault: p.IsDefault,
+			Vertex:    name + ".glslv",
+			Fragment:  name + ".glslf",
+		}
+
+		add(meta.Programs[i].Vertex, p.Vertex)
+		add(meta.Programs[i].Fragment, p.Fragment)
+	}
+
+	add("script.js", doc.Javascript)
+
+	for _, a := range doc.Assets {
+		data, err := AssetsStorage.Read(a.Hash)
+
+		if err != nil {
+			return err
+		}
+
+		base := "assets/" + archiveFileName(a.Name)
+		ext := assetExtension(data)
+		name := base
+
+		for n := 2; archiveHasFile(files, name+ext); n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		meta.Assets = append(meta.Assets, ArchiveAsset{
+			Name: a.Name,
+			Path: name + ext,
+		})
+
+		add(name+ext, string(data))
+	}
+
+	metadata, err := json.MarshalIndent(meta, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	license, err := archiveLicense(doc, source)
+
+	if err != nil {
+		return err
+	}
+
+	add("metadata.json", string(metadata)+"\n")
+	add("LICENSE", license)
+
+	zw := zip.NewWriter(w)
+
+	for _, name := range order {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: doc.CreationTime,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(f, files[name]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func readArchiveFile(files map[string]*zip.File, name string) (string, error) {
+	f := files[name]
+
+	if f == nil {
+		return "", fmt.Errorf("Archive does not contain %s", name)
+	}
+
+	r, err := f.Open()
+
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxArchiveFileSize+1))
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > maxArchiveFileSize {
+		return "", fmt.Errorf("File %s in archive is too large", name)
+	}
+
+	return string(data), nil
+}
+
+// ReadArchive reads a document from a zip archive written by WriteArchive
+func ReadArchive(data []byte) (*Document, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File)
+
+	for _, f := range zr.File {
+		files[strings.TrimPrefix(f.Name, "./")] = f
+	}
+
+	metadata, err := readArchiveFile(files, "metadata.json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var meta ArchiveMetadata
+
+	if err := json.Unmarshal([]byte(metadata), &meta); err != nil {
+		return nil, fmt.Errorf("Invalid metadata.json: %v", err)
+	}
+
+	doc := &Document{
+		Version:      meta.Version,
+		Title:        meta.Title,
+		Description:  meta.Description,
+		Programs:     make([]Program, len(meta.Programs)),
+		CreationTime: meta.CreationTime,
+		Authors:      meta.Authors,
+		Parent:       meta.Parent,
+	}
+
+	for i, p := range meta.Programs {
+		prog := Program{
+			Version:   p.Version,
+			Name:      p.Name,
+			IsDefault: p.IsDefault,
+		}
+
+		// Shaders are looked up by program name when the metadata was
+		// written by hand
+		if len(p.Vertex) == 0 {
+			p.Vertex = "programs/" + archiveFileName(p.Name) + ".glslv"
+		}
+
+		if len(p.Fragment) == 0 {
+			p.Fragment = "programs/" + archiveFileName(p.Name) + ".glslf"
+		}
+
+		if prog.Vertex, err = readArchiveFile(files, p.Vertex); err != nil {
+			return nil, err
+		}
+
+		if prog.Fragment, err = readArchiveFile(files, p.Fragment); err != nil {
+			return nil, err
+		}
+
+		doc.Programs[i] = prog
+	}
+
+	if doc.Javascript, err = readArchiveFile(files, "script.js"); err != nil {
+		return nil, err
+	}
+
+	// The document is decoded like documents which are shared directly, so
+	// that it is upgraded to the current schema and rejected when it is from
+	// a newer version
+	encoded, err := json.Marshal((*documentJSON)(doc))
+
+	if err != nil {
+		return nil, err
+	}
+
+	doc = &Document{}
+
+	if err := json.Unmarshal(encoded, doc); err != nil {
+		return nil, err
+	}
+
+	// Assets are only stored along with the document, once it has been
+	// accepted
+	for _, a := range meta.Assets {
+		data, err := readArchiveFile(files, a.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := NewAsset([]byte(data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		doc.Assets = append(doc.Assets, DocumentAsset{
+			Name: a.Name,
+			Hash: asset.Hash,
+		})
+
+		if doc.pendingAssets == nil {
+			doc.pendingAssets = make(map[string][]byte)
+		}
+
+		doc.pendingAssets[asset.Hash] = []byte(data)
+	}
+
+	return doc, nil
+}
+
+func (a ArchiveHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	doc, err := LoadSharedDocument(req, id)
+
+	if err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	if err := WriteArchive(&buf, doc, documentURL(req, id)); err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", id))
+	writer.Write(buf.Bytes())
+}
+
+// Post imports a document from an archive. The document is shared by the
+// author and license given in the query, or by the last author of the
+// document when they are not given, in which case importing an exported
+// archive results in the same document.
+func (a ImportArchiveHandler) Post(writer http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	data, err := ioutil.ReadAll(req.Body)
+
+	if err != nil {
+		a.RespondError(writer, InvalidError(ErrorInvalidRequest, "", "%s", err.Error()))
+		return
+	}
+
+	doc, err := ReadArchive(data)
+
+	if err != nil {
+		if _, ok := err.(*APIError); !ok {
+			err = InvalidError(ErrorInvalidArchive, "", "%s", err.Error())
+		}
+
+		a.RespondError(writer, err)
+		return
+	}
+
+	query := req.URL.Query()
+
+	var author Author
+
+	if len(query.Get("license")) != 0 {
+		author = Author{
+			Name:    query.Get("author"),
+			License: query.Get("license"),
+			Year:    time.Now().Year(),
+		}
+	} else if len(doc.Authors) != 0 {
+		author = doc.Authors[len(doc.Authors)-1]
+	} else {
+		a.RespondError(writer, MissingError("license", "No license specified"))
+		return
+	}
+
+	if err := doc.Prepare(author, requestKeys(req)); err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	share := DocumentShare{
+		Visibility: VisibilityPublic,
+	}
+
+	hash, err := doc.StoreNew(share)
+
+	if err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	a.RespondJSON(writer, newDocumentResponse(hash, doc, share))
+}
+
+func init() {
+	router.Handle("/d/import", MakeHandler(ImportArchiveHandler{}, WrapCORS))
+	router.Handle("/d/{id:[A-Za-z0-9]+}.zip", MakeHandler(ArchiveHandler{}, WrapCORS))
+}
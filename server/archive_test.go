@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testArchive returns a zip archive containing the given files
+func testArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for name, data := range files {
+		f, err := zw.Create(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testArchiveMetadata returns the metadata of an archive of the test document
+// with the given title, referring to shaders by program name
+func testArchiveMetadata(t *testing.T, title string, assets []ArchiveAsset) string {
+	t.Helper()
+
+	doc := newTestDocument()
+
+	data, err := json.Marshal(ArchiveMetadata{
+		Version: doc.Version,
+		Title:   title,
+		Programs: []ArchiveProgram{
+			{Version: ProgramVersion, Name: "my program", IsDefault: true},
+		},
+		Authors: doc.Authors,
+		Assets:  assets,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestArchiveFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"default", "default"},
+		{"my program", "my_program"},
+		{"../up", "_._up"},
+		{".hidden", "_hidden"},
+		{"a-b_c.d", "a-b_c.d"},
+		{"", "_"},
+	}
+
+	for _, test := range tests {
+		if got := archiveFileName(test.name); got != test.expected {
+			t.Errorf("%q: expected %q, but got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	setupTest(t)
+
+	asset, err := StoreAsset(testPNG(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := newTestDocument()
+	doc.CreationTime = time.Date(2014, 10, 1, 12, 0, 0, 0, time.UTC)
+	doc.Parent = storeTestDocument(t, newTestDocument())
+	doc.Assets = []DocumentAsset{{Name: "texture", Hash: asset.Hash}}
+
+	// Both programs map to the same file names
+	second := doc.Programs[0]
+	second.Name = "default?"
+	second.IsDefault = false
+	second.Vertex = "void main() {}\n"
+	doc.Programs = append(doc.Programs, second)
+
+	var buf bytes.Buffer
+
+	if err := WriteArchive(&buf, doc, "http://localhost/d/test"); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadArchive(buf.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := read.pendingAssets[asset.Hash]; !ok {
+		t.Errorf("expected asset %s to be pending", asset.Hash)
+	}
+
+	read.pendingAssets = nil
+
+	if !reflect.DeepEqual(doc, read) {
+		t.Errorf("expected %+v, but got %+v", doc, read)
+	}
+}
+
+func TestReadArchiveProgramNames(t *testing.T) {
+	doc := newTestDocument()
+
+	data := testArchive(t, map[string]string{
+		"metadata.json":               testArchiveMetadata(t, "Names", nil),
+		"programs/my_program.glslv":   doc.Programs[0].Vertex,
+		"programs/my_program.glslf":   doc.Programs[0].Fragment,
+		"script.js":                   doc.Javascript,
+		"programs/my program.glslv":   "wrong",
+		"./programs/my program.glslf": "wrong",
+	})
+
+	read, err := ReadArchive(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := read.Programs[0]; p.Vertex != doc.Programs[0].Vertex || p.Fragment != doc.Programs[0].Fragment {
+		t.Errorf("expected shaders of %s to be read from their archive file names, but got %+v", p.Name, p)
+	}
+}
+
+func TestImportArchiveAssets(t *testing.T) {
+	setupTest(t)
+
+	png := testPNG(t)
+	hash := hasher.Hash(png)
+	doc := newTestDocument()
+
+	assetExists := func() bool {
+		exists, err := AssetsStorage.Backend.Exists(AssetsStorage.HashPath(hash))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return exists
+	}
+
+	tests := []struct {
+		title  string
+		status int
+	}{
+		// Rejected documents do not leave their assets behind
+		{"", http.StatusBadRequest},
+		{"Assets", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		data := testArchive(t, map[string]string{
+			"metadata.json":             testArchiveMetadata(t, test.title, []ArchiveAsset{{Name: "texture", Path: "assets/texture.png"}}),
+			"programs/my_program.glslv": doc.Programs[0].Vertex,
+			"programs/my_program.glslf": doc.Programs[0].Fragment,
+			"script.js":                 doc.Javascript,
+			"assets/texture.png":        string(png),
+		})
+
+		w := serveTest(t, "POST", "/d/import", nil, bytes.NewReader(data))
+
+		if w.Code != test.status {
+			t.Errorf("%q: expected status %d, but got %d: %s", test.title, test.status, w.Code, w.Body.String())
+		}
+
+		if expected, exists := test.status == http.StatusOK, assetExists(); exists != expected {
+			t.Errorf("%q: expected asset to exist: %v, but got %v", test.title, expected, exists)
+		}
+	}
+}
+
+func TestImportArchiveVersion(t *testing.T) {
+	setupTest(t)
+
+	doc := newTestDocument()
+
+	tests := []struct {
+		version        int
+		programVersion int
+		status         int
+	}{
+		{0, 0, http.StatusOK},
+		{DocumentVersion, ProgramVersion, http.StatusOK},
+		{99, ProgramVersion, http.StatusBadRequest},
+		{DocumentVersion, 99, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		metadata, err := json.Marshal(ArchiveMetadata{
+			Version: test.version,
+			Title:   "Version",
+			Programs: []ArchiveProgram{
+				{Version: test.programVersion, Name: "my program", IsDefault: true},
+			},
+			Authors: doc.Authors,
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := testArchive(t, map[string]string{
+			"metadata.json":             string(metadata),
+			"programs/my_program.glslv": doc.Programs[0].Vertex,
+			"programs/my_program.glslf": doc.Programs[0].Fragment,
+			"script.js":                 doc.Javascript,
+		})
+
+		w := serveTest(t, "POST", "/d/import", nil, bytes.NewReader(data))
+
+		if w.Code != test.status {
+			t.Errorf("%d/%d: expected status %d, but got %d: %s", test.version, test.programVersion, test.status, w.Code, w.Body.String())
+			continue
+		}
+
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp NewDocumentResponse
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		// Documents are stored in the current schema
+		stored, err := DocumentStorage.Read(resp.Hash)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var info versionInfo
+
+		if err := json.Unmarshal(stored, &info); err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Version != DocumentVersion || len(info.Programs) != 1 || info.Programs[0].Version != ProgramVersion {
+			t.Errorf("%d/%d: expected stored document to be upgraded, but got %s", test.version, test.programVersion, stored)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	// Decoders of the image formats accepted as assets
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// maxDocumentAssets limits the number of assets a document can reference
+const maxDocumentAssets = 16
+
+type AssetOptions struct {
+	MaxSize      int `long:"max-asset-size" description:"Maximum size in kilobytes of uploaded assets" default:"1024"`
+	MaxDimension int `long:"max-asset-dimension" description:"Maximum width and height in pixels of uploaded assets" default:"4096"`
+}
+
+var AssetsStorage = &Storage{
+	Directory:     "assets",
+	ContentTypeOf: assetContentType,
+	Check:         checkAsset,
+}
+
+type NewAssetHandler struct {
+	RestishVoid
+}
+
+// Asset describes a stored image asset
+type Asset struct {
+	Hash   string `json:"hash"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+}
+
+// DocumentAsset is an asset referenced by a document. Scripts refer to
+// assets by their name.
+type DocumentAsset struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// assetTypes maps the image formats accepted as assets to their mime type
+// and file extension
+var assetTypes = map[string]struct {
+	ContentType string
+	Extension   string
+}{
+	"png":  {"image/png", ".png"},
+	"jpeg": {"image/jpeg", ".jpg"},
+}
+
+// inspectAsset checks that data is an image which can be used as an asset,
+// and returns its description
+func inspectAsset(data []byte) (*Asset, error) {
+	if len(data) > options.Assets.MaxSize<<10 {
+		return nil, &APIError{
+			Code:    ErrorAssetTooLarge,
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Asset is larger than %d kilobytes", options.Assets.MaxSize),
+		}
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, InvalidError(ErrorInvalidAsset, "", "Invalid asset, expected a PNG or JPEG image: %v", err)
+	}
+
+	typ, ok := assetTypes[format]
+
+	if !ok {
+		return nil, InvalidError(ErrorInvalidAsset, "", "Invalid asset, expected a PNG or JPEG image")
+	}
+
+	if config.Width > options.Assets.MaxDimension || config.Height > options.Assets.MaxDimension {
+		return nil, InvalidError(ErrorInvalidAsset, "", "Asset is larger than %dx%d pixels", options.Assets.MaxDimension, options.Assets.MaxDimension)
+	}
+
+	return &Asset{
+		Type:   typ.ContentType,
+		Width:  config.Width,
+		Height: config.Height,
+		Size:   len(data),
+	}, nil
+}
+
+// assetContentType returns the mime type of the format of a stored asset
+func assetContentType(data []byte) string {
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		if typ, ok := assetTypes[format]; ok {
+			return typ.ContentType
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+// assetExtension returns the file extension of an asset
+func assetExtension(data []byte) string {
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return assetTypes[format].Extension
+	}
+
+	return ""
+}
+
+func checkAsset(data []byte) error {
+	_, format, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := assetTypes[format]; !ok {
+		return fmt.Errorf("Unsupported asset format %s", format)
+	}
+
+	return nil
+}
+
+// NewAsset validates an image asset without storing it
+func NewAsset(data []byte) (*Asset, error) {
+	asset, err := inspectAsset(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAsset(data); err != nil {
+		return nil, InvalidError(ErrorInvalidAsset, "", "Invalid asset: %v", err)
+	}
+
+	asset.Hash = hasher.Hash(data)
+	return asset, nil
+}
+
+// StoreAsset validates and stores an image asset
+func StoreAsset(data []byte) (*Asset, error) {
+	asset, err := NewAsset(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := AssetsStorage.Store(data); err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
+
+// Validate checks that the asset exists, either in storage or among the
+// given pending assets. Fields of errors are relative to the asset.
+func (a *DocumentAsset) Validate(pending map[string][]byte) error {
+	if len(a.Name) == 0 {
+		return MissingError("name", "Asset does not have a name")
+	}
+
+	if !hasher.ValidHash(a.Hash) {
+		return InvalidError(ErrorInvalidField, "hash", "Invalid asset %s", a.Hash)
+	}
+
+	if _, ok := pending[a.Hash]; ok {
+		return nil
+	}
+
+	exists, err := AssetsStorage.Exists(a.Hash)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return InvalidError(ErrorAssetNotFound, "hash", "Asset %s does not exist", a.Hash)
+	}
+
+	return nil
+}
+
+// Post stores an image asset sent as the request body
+func (a NewAssetHandler) Post(writer http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	// Read one byte more than allowed to detect assets which are too large
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, int64(options.Assets.MaxSize<<10)+1))
+
+	if err != nil {
+		a.RespondError(writer, InvalidError(ErrorInvalidRequest, "", "%s", err.Error()))
+		return
+	}
+
+	asset, err := StoreAsset(data)
+
+	if err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	a.RespondJSON(writer, asset)
+}
+
+func init() {
+	router.Handle("/a/new", MakeHandler(NewAssetHandler{}, WrapCORS))
+	router.Handle("/a/{id:[A-Za-z0-9]+}", MakeHandler(AssetsStorage, WrapCORS))
+}
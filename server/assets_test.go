@@ -0,0 +1,285 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 3)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testGIF(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if err := gif.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNewAsset(t *testing.T) {
+	setupTest(t)
+
+	png := testPNG(t)
+	jpg := testJPEG(t)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		maxSize      int
+		maxDimension int
+		typ          string
+		code         string
+	}{
+		{"png", png, 1024, 4096, "image/png", ""},
+		{"jpeg", jpg, 1024, 4096, "image/jpeg", ""},
+		{"gif", testGIF(t), 1024, 4096, "", ErrorInvalidAsset},
+		{"garbage", []byte("not an image"), 1024, 4096, "", ErrorInvalidAsset},
+		{"truncated", png[:len(png)-16], 1024, 4096, "", ErrorInvalidAsset},
+		{"size", png, 0, 4096, "", ErrorAssetTooLarge},
+		{"dimension", jpg, 1024, 2, "", ErrorInvalidAsset},
+	}
+
+	for _, test := range tests {
+		options.Assets.MaxSize = test.maxSize
+		options.Assets.MaxDimension = test.maxDimension
+
+		asset, err := NewAsset(test.data)
+
+		if test.code != "" {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != test.code {
+				t.Errorf("%s: expected %s, but got %v", test.name, test.code, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if asset.Type != test.typ || asset.Hash != hasher.Hash(test.data) || asset.Size != len(test.data) {
+			t.Errorf("%s: unexpected asset %+v", test.name, asset)
+		}
+
+		if exists, err := AssetsStorage.Exists(asset.Hash); err != nil || exists {
+			t.Errorf("%s: expected asset not to be stored", test.name)
+		}
+	}
+}
+
+func TestAssetContentType(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{testPNG(t), "image/png"},
+		{testJPEG(t), "image/jpeg"},
+		{testGIF(t), "application/octet-stream"},
+		{[]byte("data"), "application/octet-stream"},
+	}
+
+	for i, test := range tests {
+		if got := assetContentType(test.data); got != test.expected {
+			t.Errorf("%d: expected %s, but got %s", i, test.expected, got)
+		}
+	}
+}
+
+func TestAssetHandlers(t *testing.T) {
+	setupTest(t)
+
+	for _, data := range [][]byte{testPNG(t), testJPEG(t)} {
+		w := serveTest(t, "POST", "/a/new", nil, bytes.NewReader(data))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var asset Asset
+
+		if err := json.Unmarshal(w.Body.Bytes(), &asset); err != nil {
+			t.Fatal(err)
+		}
+
+		w = serveTest(t, "GET", "/a/"+asset.Hash, nil, nil)
+
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+			t.Errorf("%s: expected asset to be served, but got %d", asset.Type, w.Code)
+		}
+
+		if ct := w.Header().Get("Content-Type"); ct != asset.Type {
+			t.Errorf("expected content type %s, but got %s", asset.Type, ct)
+		}
+	}
+
+	if w := serveTest(t, "POST", "/a/new", nil, bytes.NewReader([]byte("garbage"))); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid asset, but got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAssetRequestSize(t *testing.T) {
+	setupTest(t)
+
+	tests := []struct {
+		maxSize int
+		size    int
+		code    string
+	}{
+		// Requests are limited to 2MB, unless assets can be larger
+		{1024, 3 << 20, ErrorAssetTooLarge},
+		{4096, 3 << 20, ErrorInvalidAsset},
+		{4096, 5 << 20, ErrorAssetTooLarge},
+	}
+
+	for _, test := range tests {
+		options.Assets.MaxSize = test.maxSize
+
+		req := httptest.NewRequest("POST", "/a/new", bytes.NewReader(make([]byte, test.size)))
+		w := httptest.NewRecorder()
+
+		LimitedRequestHandler{}.ServeHTTP(w, req)
+
+		var resp errorResponse
+
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code != test.code {
+			t.Errorf("%d/%d: expected %s, but got %d: %s", test.maxSize, test.size, test.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDocumentAssetValidate(t *testing.T) {
+	setupTest(t)
+
+	stored, err := StoreAsset(testPNG(t))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := hasher.Hash(testJPEG(t))
+
+	tests := []struct {
+		asset DocumentAsset
+		code  string
+	}{
+		{DocumentAsset{Name: "stored", Hash: stored.Hash}, ""},
+		{DocumentAsset{Name: "pending", Hash: pending}, ""},
+		{DocumentAsset{Hash: stored.Hash}, ErrorMissingField},
+		{DocumentAsset{Name: "invalid", Hash: "../x"}, ErrorInvalidField},
+		{DocumentAsset{Name: "missing", Hash: hasher.Hash([]byte("missing"))}, ErrorAssetNotFound},
+	}
+
+	for _, test := range tests {
+		err := test.asset.Validate(map[string][]byte{pending: nil})
+
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.asset.Name, err)
+			}
+
+			continue
+		}
+
+		if aerr, ok := err.(*APIError); !ok || aerr.Code != test.code {
+			t.Errorf("%s: expected %s, but got %v", test.asset.Name, test.code, err)
+		}
+	}
+}
+
+func TestGCAssets(t *testing.T) {
+	setupTest(t)
+
+	store := func(data []byte) string {
+		asset, err := StoreAsset(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return asset.Hash
+	}
+
+	used := store(testPNG(t))
+	deleted := store(testJPEG(t))
+	unreferenced := storeTestBlob(t, AssetsStorage, "unreferenced")
+
+	doc := newTestDocument()
+	doc.Assets = []DocumentAsset{{Name: "used", Hash: used}}
+	storeTestDocument(t, doc)
+
+	doc = newTestDocument()
+	doc.Title = "Deleted"
+	doc.Assets = []DocumentAsset{{Name: "deleted", Hash: deleted}}
+	hash := storeTestDocument(t, doc)
+
+	if _, err := db.Exec("INSERT INTO gallery (document, screenshot, state) VALUES (?, ?, ?)", hash, "", StateDeleted); err != nil {
+		t.Fatal(err)
+	}
+
+	g := GarbageCollector{}
+
+	if _, err := g.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		used:         true,
+		deleted:      false,
+		unreferenced: false,
+	}
+
+	for hash, expected := range expected {
+		if exists, err := AssetsStorage.Exists(hash); err != nil || exists != expected {
+			t.Errorf("expected asset %s to exist: %v, but got %v (%v)", hash, expected, exists, err)
+		}
+	}
+}
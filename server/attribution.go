@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// AttributionTemplateBody renders an attribution notice as an html fragment.
+// The notice follows the Title, Author, Source, License practice, with one
+// sentence for the latest author and one for every adapted earlier work.
+const AttributionTemplateBody = `<p class="attribution">&ldquo;<a href="{{.Source}}">{{.Title}}</a>&rdquo; by {{template "author" .Latest}} {{template "license" .Latest}}.{{range $i, $a := .Adapted}}{{if $i}} That work is{{else}} It is{{end}} an adaptation of work by {{template "author" $a}}, {{if $a.License.PublicDomain}}dedicated to the public domain under{{else}}used under{{end}} <a href="{{$a.License.URL}}" rel="license">{{$a.License.ShortName}}</a>.{{end}}</p>
+{{define "author"}}{{if .Name}}{{.Name}}{{else}}an anonymous author{{end}} ({{.Year}}){{end}}
+{{define "license"}}{{if .License.PublicDomain}}is dedicated to the public domain under{{else}}is licensed under{{end}} <a href="{{.License.URL}}" rel="license">{{.License.ShortName}}</a>{{end}}`
+
+var attributionTemplate *template.Template
+
+type AttributionAuthor struct {
+	Name    string   `json:"name"`
+	Year    int      `json:"year"`
+	License *License `json:"license"`
+}
+
+// Attribution is the notice with which a document has to be credited when
+// it is reused. Authors are ordered from the original author to the latest.
+type Attribution struct {
+	Title   string              `json:"title"`
+	Source  string              `json:"source"`
+	Authors []AttributionAuthor `json:"authors"`
+	Text    string              `json:"text"`
+	HTML    template.HTML       `json:"html"`
+}
+
+// Latest returns the author under whose license the document is shared
+func (a *Attribution) Latest() AttributionAuthor {
+	return a.Authors[len(a.Authors)-1]
+}
+
+// Adapted returns the earlier authors, starting from the most recent one
+func (a *Attribution) Adapted() []AttributionAuthor {
+	ret := make([]AttributionAuthor, 0, len(a.Authors)-1)
+
+	for i := len(a.Authors) - 2; i >= 0; i-- {
+		ret = append(ret, a.Authors[i])
+	}
+
+	return ret
+}
+
+// documentURL returns the public address of the document with the given hash
+func documentURL(req *http.Request, hash string) string {
+	return publicURL(req) + "d/" + hash
+}
+
+// publicURL returns the public URL of the server, ending in a slash
+func publicURL(req *http.Request) string {
+	publicHost := options.PublicHost
+
+	if strings.HasPrefix(publicHost, "//") {
+		if req.TLS != nil {
+			publicHost = "https:" + publicHost
+		} else {
+			publicHost = "http:" + publicHost
+		}
+	}
+
+	if !strings.HasSuffix(publicHost, "/") {
+		publicHost += "/"
+	}
+
+	return publicHost
+}
+
+func attributionAuthorText(a AttributionAuthor) string {
+	name := a.Name
+
+	if len(name) == 0 {
+		name = "an anonymous author"
+	}
+
+	return fmt.Sprintf("%s (%d)", name, a.Year)
+}
+
+func (a *Attribution) text() string {
+	var buf bytes.Buffer
+
+	latest := a.Latest()
+
+	fmt.Fprintf(&buf, "“%s” (%s) by %s ", a.Title, a.Source, attributionAuthorText(latest))
+
+	if latest.License.PublicDomain {
+		buf.WriteString("is dedicated to the public domain under")
+	} else {
+		buf.WriteString("is licensed under")
+	}
+
+	fmt.Fprintf(&buf, " %s (%s).\n", latest.License.ShortName, latest.License.URL)
+
+	for i, author := range a.Adapted() {
+		if i == 0 {
+			buf.WriteString("It is")
+		} else {
+			buf.WriteString("That work is")
+		}
+
+		fmt.Fprintf(&buf, " an adaptation of work by %s, ", attributionAuthorText(author))
+
+		if author.License.PublicDomain {
+			buf.WriteString("dedicated to the public domain under")
+		} else {
+			buf.WriteString("used under")
+		}
+
+		fmt.Fprintf(&buf, " %s (%s).\n", author.License.ShortName, author.License.URL)
+	}
+
+	return buf.String()
+}
+
+// NewAttribution creates the attribution notice of a document, which can be
+// found at the given source address. It fails for documents without authors
+// or with an unknown license.
+func NewAttribution(doc *Document, source string) (*Attribution, error) {
+	if len(doc.Authors) == 0 {
+		return nil, fmt.Errorf("Document does not have any authors")
+	}
+
+	ret := &Attribution{
+		Title:   doc.Title,
+		Source:  source,
+		Authors: make([]AttributionAuthor, 0, len(doc.Authors)),
+	}
+
+	for _, author := range doc.Authors {
+		license := LookupLicense(author.License)
+
+		if license == nil {
+			return nil, fmt.Errorf("Unknown license %s", author.License)
+		}
+
+		ret.Authors = append(ret.Authors, AttributionAuthor{
+			Name:    author.Name,
+			Year:    author.Year,
+			License: license,
+		})
+	}
+
+	var buf bytes.Buffer
+
+	if err := attributionTemplate.Execute(&buf, ret); err != nil {
+		return nil, err
+	}
+
+	ret.Text = ret.text()
+	ret.HTML = template.HTML(strings.TrimSpace(buf.String()))
+
+	return ret, nil
+}
+
+type AttributionHandler struct {
+	RestishVoid
+}
+
+func (a AttributionHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	doc, err := LoadSharedDocument(req, id)
+
+	if err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	attribution, err := NewAttribution(doc, documentURL(req, id))
+
+	if err != nil {
+		a.RespondError(writer, err)
+		return
+	}
+
+	switch vars["format"] {
+	case "txt":
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.Write([]byte(attribution.Text))
+	case "html":
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Write([]byte(attribution.HTML))
+	default:
+		a.RespondJSON(writer, attribution)
+	}
+}
+
+func init() {
+	var err error
+
+	attributionTemplate, err = template.New("attribution").Parse(AttributionTemplateBody)
+
+	if err != nil {
+		panic(err)
+	}
+
+	router.Handle("/d/{id:[A-Za-z0-9]+}/attribution.{format:txt|html|json}", MakeHandler(AttributionHandler{}, WrapCompress|WrapCORS))
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAttribution(t *testing.T) {
+	tests := []struct {
+		title   string
+		authors []Author
+		text    string
+		html    string
+	}{
+		{
+			"Box",
+			[]Author{{Name: "A", License: "CC BY", Year: 2014}},
+			"“Box” (http://x/d/1) by A (2014) is licensed under CC BY 4.0 (https://creativecommons.org/licenses/by/4.0/).\n",
+			`<p class="attribution">&ldquo;<a href="http://x/d/1">Box</a>&rdquo; by A (2014) is licensed under <a href="https://creativecommons.org/licenses/by/4.0/" rel="license">CC BY 4.0</a>.</p>`,
+		},
+		{
+			"<b>",
+			[]Author{{License: "CC 0", Year: 2014}, {Name: "B", License: "CC BY-NC", Year: 2015}, {Name: "C", License: "CC 0", Year: 2016}},
+			"“<b>” (http://x/d/1) by C (2016) is dedicated to the public domain under CC0 1.0 (https://creativecommons.org/publicdomain/zero/1.0/).\n" +
+				"It is an adaptation of work by B (2015), used under CC BY-NC 4.0 (https://creativecommons.org/licenses/by-nc/4.0/).\n" +
+				"That work is an adaptation of work by an anonymous author (2014), dedicated to the public domain under CC0 1.0 (https://creativecommons.org/publicdomain/zero/1.0/).\n",
+			`<p class="attribution">&ldquo;<a href="http://x/d/1">&lt;b&gt;</a>&rdquo; by C (2016) is dedicated to the public domain under <a href="https://creativecommons.org/publicdomain/zero/1.0/" rel="license">CC0 1.0</a>.` +
+				` It is an adaptation of work by B (2015), used under <a href="https://creativecommons.org/licenses/by-nc/4.0/" rel="license">CC BY-NC 4.0</a>.` +
+				` That work is an adaptation of work by an anonymous author (2014), dedicated to the public domain under <a href="https://creativecommons.org/publicdomain/zero/1.0/" rel="license">CC0 1.0</a>.</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		a, err := NewAttribution(&Document{Title: test.title, Authors: test.authors}, "http://x/d/1")
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.title, err)
+			continue
+		}
+
+		if a.Text != test.text {
+			t.Errorf("%s: expected text\n%s\nbut got\n%s", test.title, test.text, a.Text)
+		}
+
+		if string(a.HTML) != test.html {
+			t.Errorf("%s: expected html\n%s\nbut got\n%s", test.title, test.html, a.HTML)
+		}
+	}
+
+	for _, authors := range [][]Author{nil, {{Name: "A", License: "GPL", Year: 2014}}} {
+		if _, err := NewAttribution(&Document{Title: "Box", Authors: authors}, "http://x/d/1"); err == nil {
+			t.Errorf("%v: expected error", authors)
+		}
+	}
+}
+
+func TestDocumentURL(t *testing.T) {
+	tests := []struct {
+		publicHost string
+		tls        bool
+		expected   string
+	}{
+		{"http://example.com/", false, "http://example.com/d/1"},
+		{"http://example.com", false, "http://example.com/d/1"},
+		{"//example.com", false, "http://example.com/d/1"},
+		{"//example.com/", true, "https://example.com/d/1"},
+	}
+
+	prev := options.PublicHost
+
+	defer func() {
+		options.PublicHost = prev
+	}()
+
+	for _, test := range tests {
+		options.PublicHost = test.publicHost
+
+		req := httptest.NewRequest("GET", "/d/1", nil)
+
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+
+		if got := documentURL(req, "1"); got != test.expected {
+			t.Errorf("%s: expected %s, but got %s", test.publicHost, test.expected, got)
+		}
+	}
+}
+
+func TestAttributionHandler(t *testing.T) {
+	setupTest(t)
+
+	hash := storeTestDocument(t, newTestDocument())
+
+	tests := []struct {
+		format      string
+		contentType string
+		contains    string
+	}{
+		{"txt", "text/plain; charset=utf-8", "“Test” (http://localhost:8000/d/" + hash + ") by Author (2014)"},
+		{"html", "text/html; charset=utf-8", `<a href="http://localhost:8000/d/` + hash + `">Test</a>`},
+		{"json", "application/json", `"shortName":"CC BY 4.0"`},
+	}
+
+	for _, test := range tests {
+		w := serveTest(t, "GET", "/d/"+hash+"/attribution."+test.format, nil, nil)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, but got %d", test.format, http.StatusOK, w.Code)
+			continue
+		}
+
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, test.contentType) {
+			t.Errorf("%s: expected content type %s, but got %s", test.format, test.contentType, ct)
+		}
+
+		if !strings.Contains(w.Body.String(), test.contains) {
+			t.Errorf("%s: expected %q in %s", test.format, test.contains, w.Body.String())
+		}
+
+		if test.format == "json" {
+			var a Attribution
+
+			if err := json.Unmarshal(w.Body.Bytes(), &a); err != nil || len(a.Authors) != 1 {
+				t.Errorf("unexpected attribution %s: %v", w.Body.String(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// blobCache is shared by all storages, or nil if caching is disabled
+var blobCache *BlobCache
+
+// BlobCache is a size bounded, least recently used, in memory cache of blob
+// data. Blobs are content addressed and never change, so cached data never
+// needs to be invalidated, only evicted.
+type BlobCache struct {
+	MaxSize int64
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	hits    uint64
+	misses  uint64
+}
+
+type blobCacheEntry struct {
+	key  string
+	data []byte
+}
+
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+	MaxSize int64  `json:"maxSize"`
+}
+
+func NewBlobCache(maxSize int64) *BlobCache {
+	return &BlobCache{
+		MaxSize: maxSize,
+
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *BlobCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*blobCacheEntry).data, true
+}
+
+func (c *BlobCache) Add(key string, data []byte) {
+	size := int64(len(data))
+
+	// Do not flush the whole cache for a single blob
+	if size > c.MaxSize/2 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&blobCacheEntry{key: key, data: data})
+	c.size += size
+
+	for c.size > c.MaxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *BlobCache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+func (c *BlobCache) remove(e *list.Element) {
+	entry := e.Value.(*blobCacheEntry)
+
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+
+	c.size -= int64(len(entry.data))
+}
+
+func (c *BlobCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+		Size:    c.size,
+		MaxSize: c.MaxSize,
+	}
+}
+
+type StatsHandler struct {
+	RestishVoid
+}
+
+type StatsResponse struct {
+	Cache CacheStats `json:"cache"`
+}
+
+func (s StatsHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	var resp StatsResponse
+
+	if blobCache != nil {
+		resp.Cache = blobCache.Stats()
+	}
+
+	s.RespondJSON(writer, resp)
+}
+
+func init() {
+	adminRouter.Handle("/stats", MakeHandler(StatsHandler{}, WrapCompress))
+}
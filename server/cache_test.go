@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBlobCache(t *testing.T) {
+	c := NewBlobCache(10)
+
+	steps := []struct {
+		op   string
+		key  string
+		size int
+		hit  bool
+	}{
+		{"add", "a", 4, false},
+		{"add", "b", 4, false},
+		{"get", "a", 0, true},
+		{"add", "c", 4, false}, // evicts b, the least recently used
+		{"get", "b", 0, false},
+		{"get", "a", 0, true},
+		{"get", "c", 0, true},
+		{"add", "d", 6, false}, // larger than half the cache, not added
+		{"get", "d", 0, false},
+		{"remove", "a", 0, false},
+		{"get", "a", 0, false},
+	}
+
+	for i, step := range steps {
+		switch step.op {
+		case "add":
+			c.Add(step.key, make([]byte, step.size))
+		case "remove":
+			c.Remove(step.key)
+		case "get":
+			if _, hit := c.Get(step.key); hit != step.hit {
+				t.Errorf("%d: expected hit %v for %s, got %v", i, step.hit, step.key, hit)
+			}
+		}
+	}
+
+	stats := c.Stats()
+
+	if stats.Hits != 3 || stats.Misses != 3 || stats.Entries != 1 || stats.Size != 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStatsOnlyOnAdminRouter(t *testing.T) {
+	if rec := serveTest(t, "GET", "/stats", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected stats to not be served publicly, got %d", rec.Code)
+	}
+
+	blobCache = NewBlobCache(1024)
+	defer func() { blobCache = nil }()
+
+	blobCache.Get("missing")
+
+	rec := httptest.NewRecorder()
+	adminRouter.ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected stats on the admin router, got %d", rec.Code)
+	}
+
+	var resp StatsResponse
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Cache.Misses != 1 {
+		t.Errorf("unexpected stats %s, %v", rec.Body.String(), err)
+	}
+}
+
+func TestStorageCache(t *testing.T) {
+	setupTest(t)
+
+	cache := NewBlobCache(1024)
+	ScreenshotsStorage.Cache = cache
+	defer func() { ScreenshotsStorage.Cache = nil }()
+
+	hash := storeTestBlob(t, ScreenshotsStorage, "cached")
+
+	for i := 0; i < 2; i++ {
+		if data, err := ScreenshotsStorage.Read(hash); err != nil || string(data) != "cached" {
+			t.Fatalf("unexpected read %q, %v", data, err)
+		}
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected the second read to be cached, got %+v", stats)
+	}
+
+	if err := ScreenshotsStorage.Remove(hash); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ScreenshotsStorage.Read(hash); err == nil {
+		t.Errorf("expected removed blob to not be served from the cache")
+	}
+}
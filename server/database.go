@@ -2,13 +2,17 @@ package main
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"math/rand"
 	"os"
 	"path"
 	"strings"
 	"time"
+	"unicode"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 )
@@ -19,7 +23,7 @@ type Db struct {
 
 var db Db
 
-const databaseVersion int32 = 1
+const databaseVersion int32 = 8
 
 const (
 	StateNew = iota
@@ -152,6 +156,106 @@ func (d *Db) Migrate() {
 		d.createIndices(tx, "views", true, []string{"id", "ip"})
 	}
 
+	if vers < 2 {
+		if _, err := tx.Exec(`CREATE TABLE aliases (
+			storage TEXT,
+			alias   TEXT,
+			hash    TEXT,
+			PRIMARY KEY (storage, alias)
+		)`); err != nil {
+			panic(err)
+		}
+	}
+
+	if vers < 3 {
+		if _, err := tx.Exec(`CREATE TABLE forks (
+			hash    TEXT PRIMARY KEY,
+			parent  TEXT,
+			created DATETIME
+		)`); err != nil {
+			panic(err)
+		}
+
+		d.createIndices(tx, "forks", false, []string{"parent"})
+	}
+
+	if vers < 4 {
+		if _, err := tx.Exec(`CREATE TABLE asset_references (
+			document TEXT,
+			asset    TEXT,
+			PRIMARY KEY (document, asset)
+		)`); err != nil {
+			panic(err)
+		}
+
+		d.createIndices(tx, "asset_references", false, []string{"asset"})
+	}
+
+	if vers < 5 {
+		if _, err := tx.Exec(`CREATE TABLE document_shares (
+			hash       TEXT,
+			visibility TEXT,
+			key        TEXT,
+			expires    DATETIME
+		)`); err != nil {
+			panic(err)
+		}
+
+		d.createIndices(tx, "document_shares", false, []string{"hash"})
+	}
+
+	if vers < 6 {
+		if _, err := tx.Exec(`CREATE TABLE tags (
+			id  INTEGER,
+			tag TEXT,
+			PRIMARY KEY (id, tag)
+		)`); err != nil {
+			panic(err)
+		}
+
+		d.createIndices(tx, "tags", false, []string{"tag"})
+	}
+
+	if vers < 7 {
+		if _, err := tx.Exec(`CREATE VIRTUAL TABLE gallery_search USING fts5 (
+			title,
+			description,
+			author,
+			shaders
+		)`); err != nil {
+			if strings.Contains(err.Error(), "no such module") {
+				panic(fmt.Errorf("%v: the server has to be built with -tags sqlite_fts5", err))
+			}
+
+			panic(err)
+		}
+
+		if err := d.rebuildGallerySearch(tx); err != nil {
+			panic(err)
+		}
+	}
+
+	if vers < 8 {
+		// Views recorded before views had a date do not count towards
+		// trending scores
+		if _, err := tx.Exec("ALTER TABLE views ADD COLUMN date DATETIME"); err != nil {
+			panic(err)
+		}
+
+		if _, err := tx.Exec("ALTER TABLE gallery ADD COLUMN trending REAL DEFAULT 0"); err != nil {
+			panic(err)
+		}
+
+		// Revisions refer to the first revision of their item, so that
+		// republished items keep the trending score of their views
+		if _, err := tx.Exec("ALTER TABLE gallery ADD COLUMN original INTEGER DEFAULT 0"); err != nil {
+			panic(err)
+		}
+
+		d.createIndices(tx, "views", false, []string{"date"})
+		d.createIndices(tx, "gallery", false, []string{"trending", "state"})
+	}
+
 	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %v", databaseVersion)); err != nil {
 		panic(err)
 	}
@@ -163,6 +267,79 @@ func (d *Db) Migrate() {
 	tx = nil
 }
 
+// gallerySearchShaders returns the shader sources of a document, as indexed
+// for searching the gallery
+func gallerySearchShaders(doc *Document) string {
+	var ret []string
+
+	for _, p := range doc.Programs {
+		ret = append(ret, p.Vertex, p.Fragment)
+	}
+
+	return strings.Join(ret, "\n")
+}
+
+// rebuildGallerySearch indexes all published gallery items for searching
+func (d *Db) rebuildGallerySearch(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM gallery_search"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, document, title, description, author FROM gallery WHERE state = ?", StatePublished)
+
+	if err != nil {
+		return err
+	}
+
+	var items []*GalleryItem
+
+	for rows.Next() {
+		item := new(GalleryItem)
+
+		if err := rows.Scan(&item.Id, &item.Document, &item.Title, &item.Description, &item.Author); err != nil {
+			rows.Close()
+			return err
+		}
+
+		items = append(items, item)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var shaders string
+
+		// Items are still indexed by their text when their document can
+		// not be read
+		if doc, err := LoadDocument(item.Document); err != nil {
+			log.Printf("Failed to index the shaders of gallery item %d: %v", item.Id, err)
+		} else {
+			shaders = gallerySearchShaders(doc)
+		}
+
+		if err := d.indexGalleryItem(tx, item, shaders); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Db) indexGalleryItem(tx *sql.Tx, item *GalleryItem, shaders string) error {
+	_, err := tx.Exec("INSERT INTO gallery_search (rowid, title, description, author, shaders) VALUES (?, ?, ?, ?, ?)",
+		item.Id,
+		item.Title,
+		item.Description,
+		item.Author,
+		shaders)
+
+	return err
+}
+
 type GalleryItem struct {
 	Id               int       `json:"id"`
 	Parent           int       `json:"parent"`
@@ -174,11 +351,45 @@ type GalleryItem struct {
 	Author           string    `json:"author"`
 	License          string    `json:"license"`
 	Views            int       `json:"views"`
+	Trending         float64   `json:"-"`
+	Original         int       `json:"-"`
 	ModificationDate time.Time `json:"modificationDate"`
+	Tags             []string  `json:"tags"`
 	State            int       `json:"-"`
+
+	// Snippet is an html fragment of the text matching a search, in which
+	// the matching terms are marked
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// GalleryQuery selects the published items listed by Db.Gallery
+type GalleryQuery struct {
+	Page     int
+	Limit    int
+	Sort     string
+	Reversed bool
+
+	// Tags restricts the listing to items which have all of the tags
+	Tags []string
+
+	// Search restricts the listing to items matching a full text search
+	// query, which are listed by relevance unless a sort is given
+	Search string
+
+	// After, when not nil, continues the listing after the cursor instead of
+	// at Page
+	After *GalleryCursor
+}
+
+// TagCount is the number of published items with a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
-func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
+// PutGallery publishes a new revision of the gallery item with the token of
+// item, which shares the given document
+func (d *Db) PutGallery(item *GalleryItem, doc *Document, screenshotData []byte) error {
 	tx, err := d.Begin()
 
 	defer func() {
@@ -192,12 +403,12 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 	}
 
 	// Transfer views
-	cur := tx.QueryRow("SELECT id, parent, views, state FROM gallery WHERE token = ?", item.Token)
+	cur := tx.QueryRow("SELECT id, parent, views, trending, original, state FROM gallery WHERE token = ?", item.Token)
 
 	state := 0
 
 	if cur != nil {
-		if err := cur.Scan(&item.Id, &item.Parent, &item.Views, &state); err != nil {
+		if err := cur.Scan(&item.Id, &item.Parent, &item.Views, &item.Trending, &item.Original, &state); err != nil {
 			log.Printf("Error while scanning current document: %v", err)
 			return err
 		}
@@ -205,6 +416,10 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 
 	// Demote current document to revision
 	if state != StateNew {
+		if item.Original == 0 {
+			item.Original = item.Id
+		}
+
 		if _, err := tx.Exec(`
 			UPDATE OR FAIL
 				gallery
@@ -242,9 +457,9 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 		INSERT INTO
 			gallery
 		(
-			parent, token, document, title, description, screenshot, author, license, views, modificationDate, state
+			parent, token, document, title, description, screenshot, author, license, views, trending, original, modificationDate, state
 		) VALUES (
-			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		)`,
 		item.Parent,
 		item.Token,
@@ -255,6 +470,8 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 		item.Author,
 		item.License,
 		item.Views,
+		item.Trending,
+		item.Original,
 		item.ModificationDate,
 		item.State)
 
@@ -270,6 +487,29 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 		return err
 	}
 
+	for _, tag := range item.Tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (id, tag) VALUES (?, ?)", nid, tag); err != nil {
+			log.Printf("Error while tagging new document: %v", err)
+			return err
+		}
+	}
+
+	// The revision replaces the demoted document in the search index
+	if _, err := tx.Exec("DELETE FROM gallery_search WHERE rowid = ?", item.Id); err != nil {
+		log.Printf("Error while removing demoted document from the search index: %v", err)
+		return err
+	}
+
+	if err := d.indexGalleryItem(tx, &GalleryItem{
+		Id:          int(nid),
+		Title:       item.Title,
+		Description: item.Description,
+		Author:      item.Author,
+	}, gallerySearchShaders(doc)); err != nil {
+		log.Printf("Error while indexing new document: %v", err)
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("Error while committing document update the transaction: %v", err)
 		return err
@@ -281,110 +521,936 @@ func (d *Db) PutGallery(item *GalleryItem, screenshotData []byte) error {
 	return nil
 }
 
-func (d *Db) Gallery(page int, n int, sort string, reversed bool) ([]*GalleryItem, error) {
+// gallerySearchExpression converts a search query to a full text search
+// expression, in which every word is a phrase and the last word matches as
+// a prefix
+func gallerySearchExpression(search string) string {
+	var phrases []string
+
+	for _, word := range strings.Fields(search) {
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+
+		phrases = append(phrases, `"`+strings.Replace(word, `"`, `""`, -1)+`"`)
+	}
+
+	if len(phrases) == 0 {
+		return ""
+	}
+
+	return strings.Join(phrases, " ") + "*"
+}
+
+// gallerySnippet converts a snippet of a search, in which matching terms are
+// delimited by \x01 and \x02, to html
+func gallerySnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.Replace(snippet, "\x01", "<mark>", -1)
+
+	return strings.Replace(snippet, "\x02", "</mark>", -1)
+}
+
+// GalleryCursor is the position of an item in a gallery listing, after which
+// the listing continues. Items are ordered by their sort key, and then by id,
+// so that a listing does not shift when items are published in the meantime.
+// Relevance scores do not survive being encoded, so searches ranked by
+// relevance continue at the rank of the item instead.
+type GalleryCursor struct {
+	Sort     string          `json:"s"`
+	Reversed bool            `json:"r,omitempty"`
+	Key      json.RawMessage `json:"k"`
+	Id       int             `json:"i"`
+}
+
+// ParseGalleryCursor parses a cursor as returned by GalleryCursor.String
+func ParseGalleryCursor(s string) (*GalleryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor GalleryCursor
+
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	if _, err := cursor.key(); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+func newGalleryCursor(query GalleryQuery, item *GalleryItem, rank int) (*GalleryCursor, error) {
+	var key interface{}
+
+	switch query.Sort {
+	case "views":
+		key = item.Views
+	case "trending":
+		key = item.Trending
+	case "relevance":
+		key = rank
+	default:
+		key = item.ModificationDate
+	}
+
+	data, err := json.Marshal(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &GalleryCursor{
+		Sort:     query.Sort,
+		Reversed: query.Reversed,
+		Key:      data,
+		Id:       item.Id,
+	}, nil
+}
+
+// key returns the sort key of the cursor, as it is compared in queries
+func (c *GalleryCursor) key() (interface{}, error) {
+	var err error
+
+	switch c.Sort {
+	case "views":
+		var views int
+		err = json.Unmarshal(c.Key, &views)
+		return views, err
+	case "relevance":
+		var rank int
+
+		if err = json.Unmarshal(c.Key, &rank); err == nil && rank < 0 {
+			err = fmt.Errorf("Invalid gallery cursor rank %d", rank)
+		}
+
+		return rank, err
+	case "trending":
+		var score float64
+		err = json.Unmarshal(c.Key, &score)
+		return score, err
+	case "newest":
+		var date time.Time
+		err = json.Unmarshal(c.Key, &date)
+		return date, err
+	}
+
+	return nil, fmt.Errorf("Unknown gallery sort %s", c.Sort)
+}
+
+func (c *GalleryCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Gallery lists published gallery items. It returns the cursor after the last
+// item, or nil if there are no more items.
+func (d *Db) Gallery(query GalleryQuery) ([]*GalleryItem, *GalleryCursor, error) {
+	// Only searches can be ranked by relevance
+	if query.Sort == "relevance" && len(query.Search) == 0 {
+		query.Sort = "newest"
+	}
+
 	var orderBy string
+	ascending := false
 
-	switch sort {
+	switch query.Sort {
 	case "views":
-		orderBy = "views"
+		orderBy = "gallery.views"
+	case "trending":
+		orderBy = "gallery.trending"
+	case "relevance":
+		// Matches in titles are most relevant, and matches in shaders least
+		orderBy = "bm25(gallery_search, 10.0, 5.0, 2.0, 1.0)"
+		ascending = true
 	default:
-		orderBy = "modificationDate"
+		query.Sort = "newest"
+		orderBy = "gallery.modificationDate"
 	}
 
-	var orderDir string
+	var orderDir, afterOp string
 
-	if !reversed {
-		orderDir = "DESC"
-	} else {
+	if ascending != query.Reversed {
 		orderDir = "ASC"
+		afterOp = ">"
+	} else {
+		orderDir = "DESC"
+		afterOp = "<"
+	}
+
+	from := "gallery"
+	snippet := "''"
+	where := "gallery.state = ?"
+	args := []interface{}{StatePublished}
+
+	if len(query.Search) != 0 {
+		expr := gallerySearchExpression(query.Search)
+
+		if len(expr) == 0 {
+			return []*GalleryItem{}, nil, nil
+		}
+
+		from += " JOIN gallery_search ON gallery_search.rowid = gallery.id"
+		snippet = "snippet(gallery_search, -1, char(1), char(2), '…', 16)"
+		where += " AND gallery_search MATCH ?"
+		args = append(args, expr)
 	}
 
+	for _, tag := range query.Tags {
+		where += " AND gallery.id IN (SELECT id FROM tags WHERE tag = ?)"
+		args = append(args, tag)
+	}
+
+	offset := query.Page * query.Limit
+
+	if query.After != nil {
+		if query.After.Sort != query.Sort || query.After.Reversed != query.Reversed {
+			return nil, nil, fmt.Errorf("Gallery cursor does not match the sort of the listing")
+		}
+
+		key, err := query.After.key()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if query.Sort == "relevance" {
+			offset = key.(int)
+		} else {
+			where += fmt.Sprintf(" AND (%s, gallery.id) %s (?, ?)", orderBy, afterOp)
+			args = append(args, key, query.After.Id)
+			offset = 0
+		}
+	}
+
+	// One more item than requested is queried to find out whether there are
+	// any more items after the listing
+	args = append(args, query.Limit+1, offset)
+
 	q := fmt.Sprintf(`
 		SELECT
-			id,
-			parent,
-			document,
-			title,
-			description,
-			screenshot,
-			author,
-			license,
-			views,
-			modificationDate
+			gallery.id,
+			gallery.parent,
+			gallery.document,
+			gallery.title,
+			gallery.description,
+			gallery.screenshot,
+			gallery.author,
+			gallery.license,
+			gallery.views,
+			gallery.trending,
+			gallery.modificationDate,
+			%s
 		FROM
-			gallery
+			%s
 		WHERE
-			state = ?
+			%s
 		ORDER BY
-			%s %s
+			%s %s,
+			gallery.id %s
 		LIMIT
-			%d
+			?
 		OFFSET
-			%d`, orderBy, orderDir, n, page*n)
+			?`, snippet, from, where, orderBy, orderDir, orderDir)
 
-	rows, err := d.Query(q, StatePublished)
+	rows, err := d.Query(q, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer rows.Close()
 
-	ret := make([]*GalleryItem, 0, n)
+	ret := make([]*GalleryItem, 0, query.Limit)
+	more := false
 
 	for rows.Next() {
+		if len(ret) == query.Limit {
+			more = true
+			break
+		}
+
 		var item = new(GalleryItem)
 
-		err = rows.Scan(&item.Id, &item.Parent, &item.Document, &item.Title, &item.Description, &item.Screenshot, &item.Author, &item.License, &item.Views, &item.ModificationDate)
+		err = rows.Scan(&item.Id, &item.Parent, &item.Document, &item.Title, &item.Description, &item.Screenshot, &item.Author, &item.License, &item.Views, &item.Trending, &item.ModificationDate, &item.Snippet)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
+		item.Snippet = gallerySnippet(item.Snippet)
 		ret = append(ret, item)
 	}
 
-	return ret, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *GalleryCursor
+
+	if more && len(ret) != 0 {
+		if next, err = newGalleryCursor(query, ret[len(ret)-1], offset+len(ret)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := d.galleryTags(ret); err != nil {
+		return nil, nil, err
+	}
+
+	return ret, next, nil
 }
 
-func (d *Db) GalleryView(parent int, id int, iphash string) {
-	tx, err := d.Begin()
+// galleryTags fills in the tags of gallery items
+func (d *Db) galleryTags(items []*GalleryItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	byId := make(map[int]*GalleryItem)
+	ids := make([]interface{}, len(items))
+
+	for i, item := range items {
+		item.Tags = []string{}
+		byId[item.Id] = item
+		ids[i] = item.Id
+	}
+
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+	rows, err := d.Query("SELECT id, tag FROM tags WHERE id IN ("+placeholders+") ORDER BY tag", ids...)
 
 	if err != nil {
-		log.Printf("Failed to create view update transaction: %v", err)
-		return
+		return err
 	}
 
-	defer func() {
-		if tx != nil {
-			tx.Rollback()
-		}
-	}()
+	defer rows.Close()
 
-	var viewid int
+	for rows.Next() {
+		var id int
+		var tag string
 
-	if parent > 0 {
-		viewid = parent
-	} else {
-		viewid = id
-	}
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
 
-	if _, err := tx.Exec("INSERT INTO views (id, ip) VALUES (?, ?)", viewid, iphash); err != nil {
-		log.Printf("Failed to create view: %v", err)
-		return
+		byId[id].Tags = append(byId[id].Tags, tag)
 	}
 
-	if _, err := tx.Exec("UPDATE gallery SET views = views + 1 WHERE parent = ? AND id = ?", parent, id); err != nil {
-		log.Printf("Failed to update item views: %v", err)
-		return
+	return rows.Err()
+}
+
+// GalleryTags returns the number of published items with each tag, most
+// used tags first
+func (d *Db) GalleryTags() ([]TagCount, error) {
+	rows, err := d.Query(`
+		SELECT
+			tags.tag,
+			COUNT(*) AS count
+		FROM
+			tags
+		JOIN
+			gallery ON gallery.id = tags.id
+		WHERE
+			gallery.state = ?
+		GROUP BY
+			tags.tag
+		ORDER BY
+			count DESC,
+			tags.tag ASC
+	`, StatePublished)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit view update: %v", err)
-		return
+	defer rows.Close()
+
+	ret := []TagCount{}
+
+	for rows.Next() {
+		var tc TagCount
+
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, tc)
 	}
 
-	tx = nil
+	return ret, rows.Err()
+}
+
+func (d *Db) GalleryReferences() (map[string]bool, map[string]bool, error) {
+	rows, err := d.Query("SELECT document, screenshot, state FROM gallery WHERE state != ?", StateNew)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	documents := make(map[string]bool)
+	screenshots := make(map[string]bool)
+
+	for rows.Next() {
+		var document, screenshot sql.NullString
+		var state int
+
+		if err := rows.Scan(&document, &screenshot, &state); err != nil {
+			return nil, nil, err
+		}
+
+		live := state == StatePublished || state == StateRevision
+
+		if document.Valid {
+			documents[document.String] = documents[document.String] || live
+		}
+
+		if screenshot.Valid {
+			screenshots[screenshot.String] = screenshots[screenshot.String] || live
+		}
+	}
+
+	return documents, screenshots, rows.Err()
+}
+
+// ResolveAlias returns the hash of a blob which has been rekeyed from the
+// given alias, or an empty string if there is no such alias
+func (d *Db) ResolveAlias(storage string, alias string) (string, error) {
+	var hash string
+
+	err := d.QueryRow("SELECT hash FROM aliases WHERE storage = ? AND alias = ?", storage, alias).Scan(&hash)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return hash, err
+}
+
+// AddAlias records that the blob with the given alias is to be found under
+// hash instead
+func (d *Db) AddAlias(storage string, alias string, hash string) error {
+	_, err := d.Exec("INSERT OR REPLACE INTO aliases (storage, alias, hash) VALUES (?, ?, ?)", storage, alias, hash)
+	return err
+}
+
+// Rekey records that the blob previously stored under the alias hash in the
+// given storage is now stored under hash, and updates all gallery items which
+// reference it
+func (d *Db) Rekey(storage string, alias string, hash string) error {
+	var column string
+
+	switch storage {
+	case DocumentStorage.Directory:
+		column = "document"
+	case ScreenshotsStorage.Directory:
+		column = "screenshot"
+	}
+
+	tx, err := d.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO aliases (storage, alias, hash) VALUES (?, ?, ?)", storage, alias, hash); err != nil {
+		return err
+	}
+
+	if len(column) != 0 {
+		if _, err := tx.Exec("UPDATE gallery SET "+column+" = ? WHERE "+column+" = ?", hash, alias); err != nil {
+			return err
+		}
+	}
+
+	if storage == DocumentStorage.Directory {
+		if _, err := tx.Exec("UPDATE forks SET hash = ? WHERE hash = ?", hash, alias); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE forks SET parent = ? WHERE parent = ?", hash, alias); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE OR IGNORE asset_references SET document = ? WHERE document = ?", hash, alias); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE document_shares SET hash = ? WHERE hash = ?", hash, alias); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tx = nil
+	return nil
+}
+
+// AddFork records that the document with the given hash was forked from the
+// parent document
+func (d *Db) AddFork(hash string, parent string) error {
+	_, err := d.Exec("INSERT OR IGNORE INTO forks (hash, parent, created) VALUES (?, ?, ?)", hash, parent, time.Now())
+	return err
+}
+
+// Forks returns the hashes of the documents forked from the given parent
+// document, oldest first
+func (d *Db) Forks(parent string) ([]string, error) {
+	rows, err := d.Query("SELECT hash FROM forks WHERE parent = ? ORDER BY created", parent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []string
+
+	for rows.Next() {
+		var hash string
+
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, hash)
+	}
+
+	return ret, rows.Err()
+}
+
+// AddAssetReference records that the document with the given hash uses the
+// given asset
+func (d *Db) AddAssetReference(document string, asset string) error {
+	_, err := d.Exec("INSERT OR IGNORE INTO asset_references (document, asset) VALUES (?, ?)", document, asset)
+	return err
+}
+
+// RemoveAssetReferences removes the asset references of the document with
+// the given hash
+func (d *Db) RemoveAssetReferences(document string) error {
+	_, err := d.Exec("DELETE FROM asset_references WHERE document = ?", document)
+	return err
+}
+
+// AssetReferences returns the documents referencing each asset
+func (d *Db) AssetReferences() (map[string][]string, error) {
+	rows, err := d.Query("SELECT document, asset FROM asset_references")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make(map[string][]string)
+
+	for rows.Next() {
+		var document, asset string
+
+		if err := rows.Scan(&document, &asset); err != nil {
+			return nil, err
+		}
+
+		ret[asset] = append(ret[asset], document)
+	}
+
+	return ret, rows.Err()
+}
+
+// AddDocumentShare records a share of the document with the given hash.
+// Documents without any shares are public, so a document which existed
+// before is first shared publicly to keep sharing identical content again
+// from restricting it.
+func (d *Db) AddDocumentShare(hash string, share DocumentShare, existed bool) error {
+	var expires interface{}
+
+	if !share.Expires.IsZero() {
+		expires = share.Expires.UTC()
+	}
+
+	tx, err := d.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if existed {
+		if err := addImplicitShare(tx, hash); err != nil {
+			return err
+		}
+	}
+
+	// Sharing a document in the same way again does not add a share
+	if _, err := tx.Exec(`
+		INSERT INTO
+			document_shares (hash, visibility, key, expires)
+		SELECT
+			?, ?, ?, ?
+		WHERE NOT EXISTS (
+			SELECT
+				1
+			FROM
+				document_shares
+			WHERE
+				hash = ? AND visibility = ? AND key = ? AND expires IS ?
+		)
+	`, hash, share.Visibility, share.Key, expires, hash, share.Visibility, share.Key, expires); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tx = nil
+	return nil
+}
+
+// addImplicitShare records the public share which a document without any
+// shares implicitly has
+func addImplicitShare(tx *sql.Tx, hash string) error {
+	_, err := tx.Exec(`
+		INSERT INTO
+			document_shares (hash, visibility, key, expires)
+		SELECT
+			?, ?, '', NULL
+		WHERE NOT EXISTS (
+			SELECT
+				1
+			FROM
+				document_shares
+			WHERE
+				hash = ?
+		)
+	`, hash, VisibilityPublic, hash)
+
+	return err
+}
+
+// DocumentShares returns the shares of the document with the given hash
+func (d *Db) DocumentShares(hash string) ([]DocumentShare, error) {
+	rows, err := d.Query("SELECT visibility, key, expires FROM document_shares WHERE hash = ?", hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []DocumentShare
+
+	for rows.Next() {
+		var share DocumentShare
+		var expires sql.NullTime
+
+		if err := rows.Scan(&share.Visibility, &share.Key, &expires); err != nil {
+			return nil, err
+		}
+
+		if expires.Valid {
+			share.Expires = expires.Time
+		}
+
+		ret = append(ret, share)
+	}
+
+	return ret, rows.Err()
+}
+
+// CopyDocumentShares shares the document stored under hash in the same way as
+// the document it was upgraded from. Like with AddDocumentShare, a document
+// which existed before is first shared publicly when it has no shares.
+func (d *Db) CopyDocumentShares(from string, hash string, existed bool) error {
+	tx, err := d.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if existed {
+		if err := addImplicitShare(tx, hash); err != nil {
+			return err
+		}
+	}
+
+	// Shares which the document already has are not copied again
+	if _, err := tx.Exec(`
+		INSERT INTO
+			document_shares (hash, visibility, key, expires)
+		SELECT
+			?, s.visibility, s.key, s.expires
+		FROM
+			document_shares s
+		WHERE
+			s.hash = ? AND NOT EXISTS (
+				SELECT
+					1
+				FROM
+					document_shares e
+				WHERE
+					e.hash = ? AND
+					e.visibility = s.visibility AND
+					e.key = s.key AND
+					e.expires IS s.expires
+			)
+	`, hash, from, hash); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tx = nil
+	return nil
+}
+
+// SharedDocuments returns the hashes of the documents which have a share
+// which has not expired
+func (d *Db) SharedDocuments(now time.Time) (map[string]bool, error) {
+	rows, err := d.Query("SELECT DISTINCT hash FROM document_shares WHERE expires IS NULL OR expires > ?", now.UTC())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make(map[string]bool)
+
+	for rows.Next() {
+		var hash string
+
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		ret[hash] = true
+	}
+
+	return ret, rows.Err()
+}
+
+// ExpiredDocuments returns the hashes of the documents of which all shares
+// have expired
+func (d *Db) ExpiredDocuments(now time.Time) ([]string, error) {
+	rows, err := d.Query(`
+		SELECT
+			hash
+		FROM
+			document_shares
+		GROUP BY
+			hash
+		HAVING
+			SUM(expires IS NULL OR expires > ?) = 0
+	`, now.UTC())
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []string
+
+	for rows.Next() {
+		var hash string
+
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, hash)
+	}
+
+	return ret, rows.Err()
+}
+
+// RemoveDocumentShares removes the shares of the document with the given hash
+func (d *Db) RemoveDocumentShares(hash string) error {
+	_, err := d.Exec("DELETE FROM document_shares WHERE hash = ?", hash)
+	return err
+}
+
+// RemoveDocument forgets the fork and the asset references of a removed
+// document
+func (d *Db) RemoveDocument(hash string) error {
+	if _, err := d.Exec("DELETE FROM forks WHERE hash = ?", hash); err != nil {
+		return err
+	}
+
+	// Aliases can not resolve to a removed document
+	if _, err := d.Exec("DELETE FROM aliases WHERE storage = ? AND hash = ?", DocumentStorage.Directory, hash); err != nil {
+		return err
+	}
+
+	return d.RemoveAssetReferences(hash)
+}
+
+func (d *Db) GalleryView(parent int, id int, iphash string) {
+	tx, err := d.Begin()
+
+	if err != nil {
+		log.Printf("Failed to create view update transaction: %v", err)
+		return
+	}
+
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var viewid int
+
+	if parent > 0 {
+		viewid = parent
+	} else {
+		viewid = id
+	}
+
+	if _, err := tx.Exec("INSERT INTO views (id, ip, date) VALUES (?, ?, ?)", viewid, iphash, time.Now()); err != nil {
+		log.Printf("Failed to create view: %v", err)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE gallery SET views = views + 1 WHERE parent = ? AND id = ?", parent, id); err != nil {
+		log.Printf("Failed to update item views: %v", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit view update: %v", err)
+		return
+	}
+
+	tx = nil
+}
+
+// UpdateTrending sets the trending scores of published gallery items to the
+// sum of the weights of their views since the given time, including the views
+// of the revisions they replaced. Items without views since then have a score
+// of 0.
+func (d *Db) UpdateTrending(since time.Time, weight func(date time.Time) float64) error {
+	tx, err := d.Begin()
+
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, date FROM views WHERE date > ?", since)
+
+	if err != nil {
+		return err
+	}
+
+	scores := make(map[int]float64)
+
+	for rows.Next() {
+		var id int
+		var date time.Time
+
+		if err := rows.Scan(&id, &date); err != nil {
+			rows.Close()
+			return err
+		}
+
+		scores[id] += weight(date)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Views stay with the revision they were recorded for, and are summed
+	// by the first revision of their item
+	rows, err = tx.Query("SELECT id, original, state FROM gallery WHERE state = ? OR state = ?", StatePublished, StateRevision)
+
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[int]float64)
+	published := make(map[int]int)
+
+	for rows.Next() {
+		var id, original, state int
+
+		if err := rows.Scan(&id, &original, &state); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if original == 0 {
+			original = id
+		}
+
+		totals[original] += scores[id]
+
+		if state == StatePublished {
+			published[id] = original
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE gallery SET trending = 0 WHERE trending != 0"); err != nil {
+		return err
+	}
+
+	for id, original := range published {
+		if score := totals[original]; score != 0 {
+			if _, err := tx.Exec("UPDATE gallery SET trending = ? WHERE id = ?", score, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tx = nil
+	return nil
 }
 
 func (d *Db) Open() {
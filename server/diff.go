@@ -0,0 +1,534 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// diffContext is the number of unchanged lines around changes in a hunk
+const diffContext = 3
+
+// maxDiffEdits limits the number of edits the diff algorithm searches for,
+// beyond which the remaining lines are simply replaced
+const maxDiffEdits = 1000
+
+type DiffHandler struct {
+	RestishVoid
+}
+
+type StringChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ProgramDiff contains unified diffs of the shaders of a program which
+// changed. Name is the name of the program in the newer document.
+type ProgramDiff struct {
+	Name     string `json:"name"`
+	Vertex   string `json:"vertex,omitempty"`
+	Fragment string `json:"fragment,omitempty"`
+}
+
+type ProgramsDiff struct {
+	Added   []string       `json:"added"`
+	Removed []string       `json:"removed"`
+	Renamed []StringChange `json:"renamed"`
+	Changed []ProgramDiff  `json:"changed"`
+	Default *StringChange  `json:"default,omitempty"`
+}
+
+// AuthorsDiff contains the authors removed from the end of the author chain,
+// and the authors added after them
+type AuthorsDiff struct {
+	Removed []Author `json:"removed"`
+	Added   []Author `json:"added"`
+}
+
+type DocumentDiff struct {
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Title       *StringChange `json:"title,omitempty"`
+	Description *StringChange `json:"description,omitempty"`
+	Programs    ProgramsDiff  `json:"programs"`
+	Javascript  string        `json:"javascript,omitempty"`
+	Authors     AuthorsDiff   `json:"authors"`
+}
+
+type diffOp struct {
+	Kind byte
+	Line string
+
+	// A and B are the indices of the line in the old and new text
+	A int
+	B int
+}
+
+// splitDiffLines splits text into lines, keeping the line endings so that a
+// missing newline at the end of the text is detected as a change
+func splitDiffLines(text string) []string {
+	if len(text) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffLines computes the edit script transforming a into b, using the linear
+// space variant of the algorithm described in "An O(ND) Difference Algorithm
+// and Its Variations" by Eugene W. Myers
+func diffLines(a []string, b []string) []diffOp {
+	d := lineDiffer{a: a, b: b}
+	d.diff(0, len(a), 0, len(b))
+
+	return d.ops
+}
+
+// lineDiffer collects the edit script of two texts, which it computes by
+// recursively splitting them where the shortest edit script crosses the
+// middle
+type lineDiffer struct {
+	a   []string
+	b   []string
+	ops []diffOp
+
+	// v is reused for the furthest reaching paths of each split
+	v []int
+}
+
+func (d *lineDiffer) same(x int, y int) {
+	d.ops = append(d.ops, diffOp{Kind: ' ', Line: d.a[x], A: x, B: y})
+}
+
+func (d *lineDiffer) remove(x int, y int) {
+	d.ops = append(d.ops, diffOp{Kind: '-', Line: d.a[x], A: x, B: y})
+}
+
+func (d *lineDiffer) insert(x int, y int) {
+	d.ops = append(d.ops, diffOp{Kind: '+', Line: d.b[y], A: x, B: y})
+}
+
+// diff appends the edit script transforming a[x0:x1] into b[y0:y1]
+func (d *lineDiffer) diff(x0 int, x1 int, y0 int, y1 int) {
+	// Common prefixes and suffixes are not part of the search
+	for x0 < x1 && y0 < y1 && d.a[x0] == d.b[y0] {
+		d.same(x0, y0)
+		x0++
+		y0++
+	}
+
+	suffix := 0
+
+	for x0 < x1-suffix && y0 < y1-suffix && d.a[x1-1-suffix] == d.b[y1-1-suffix] {
+		suffix++
+	}
+
+	x1 -= suffix
+	y1 -= suffix
+
+	x, y, ok := 0, 0, false
+
+	if x0 != x1 && y0 != y1 {
+		x, y, ok = d.split(x0, x1, y0, y1)
+	}
+
+	if ok {
+		d.diff(x0, x, y0, y)
+		d.diff(x, x1, y, y1)
+	} else {
+		// Either text is empty, or there are too many differences, so all
+		// the lines are replaced
+		for x := x0; x < x1; x++ {
+			d.remove(x, y0)
+		}
+
+		for y := y0; y < y1; y++ {
+			d.insert(x1, y)
+		}
+	}
+
+	for i := suffix; i > 0; i-- {
+		d.same(x1+suffix-i, y1+suffix-i)
+	}
+}
+
+// split finds a point on a shortest edit script transforming a[x0:x1] into
+// b[y0:y1] by searching from both ends until the paths overlap. It fails
+// when the script has more than maxDiffEdits edits.
+func (d *lineDiffer) split(x0 int, x1 int, y0 int, y1 int) (int, int, bool) {
+	n, m := x1-x0, y1-y0
+	maxD := (n + m + 1) / 2
+
+	// The forward and backward paths are stored in v by diagonal, offset to
+	// be positive
+	offset := maxD
+	size := 2*maxD + 2
+
+	if cap(d.v) < 2*size {
+		d.v = make([]int, 2*size)
+	}
+
+	vf, vb := d.v[:size], d.v[size:2*size]
+
+	for i := range vf {
+		vf[i] = -1
+		vb[i] = -1
+	}
+
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+
+	delta := n - m
+
+	// With an odd delta the paths can only overlap while extending the
+	// forward path, and otherwise while extending the backward path
+	front := delta%2 != 0
+
+	// Diagonals which left the grid are not extended any further
+	fstart, fend, bstart, bend := 0, 0, 0, 0
+
+	for e := 0; e < maxD && 2*e <= maxDiffEdits; e++ {
+		for k := -e + fstart; k <= e-fend; k += 2 {
+			var x int
+
+			if k == -e || (k != e && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+
+			y := x - k
+
+			for x < n && y < m && d.a[x0+x] == d.b[y0+y] {
+				x++
+				y++
+			}
+
+			vf[offset+k] = x
+
+			if x > n {
+				fend += 2
+			} else if y > m {
+				fstart += 2
+			} else if front {
+				if kb := offset + delta - k; kb >= 0 && kb < size && vb[kb] != -1 && x >= n-vb[kb] {
+					return x0 + x, y0 + y, true
+				}
+			}
+		}
+
+		for k := -e + bstart; k <= e-bend; k += 2 {
+			var x int
+
+			if k == -e || (k != e && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+
+			y := x - k
+
+			for x < n && y < m && d.a[x1-1-x] == d.b[y1-1-y] {
+				x++
+				y++
+			}
+
+			vb[offset+k] = x
+
+			if x > n {
+				bend += 2
+			} else if y > m {
+				bstart += 2
+			} else if !front {
+				if kf := offset + delta - k; kf >= 0 && kf < size && vf[kf] != -1 && vf[kf] >= n-x {
+					xf := vf[kf]
+					return x0 + xf, y0 + xf - (kf - offset), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// hunkRange formats the range of a hunk header. Empty ranges start at the
+// line before the hunk.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// UnifiedDiff returns the line based unified diff between two texts, or an
+// empty string if they are equal
+func UnifiedDiff(fromName string, toName string, from string, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(splitDiffLines(from), splitDiffLines(to))
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].Kind == ' ' {
+			i++
+		}
+
+		if i == len(ops) {
+			break
+		}
+
+		start := i - diffContext
+
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk up to the first run of unchanged lines which is long
+		// enough to separate it from the next hunk
+		end := i
+
+		for end < len(ops) {
+			if ops[end].Kind != ' ' {
+				end++
+				continue
+			}
+
+			next := end
+
+			for next < len(ops) && ops[next].Kind == ' ' {
+				next++
+			}
+
+			if next == len(ops) || next-end > 2*diffContext {
+				end += diffContext
+
+				if end > len(ops) {
+					end = len(ops)
+				}
+
+				break
+			}
+
+			end = next
+		}
+
+		na, nb := 0, 0
+
+		for _, op := range ops[start:end] {
+			if op.Kind != '+' {
+				na++
+			}
+
+			if op.Kind != '-' {
+				nb++
+			}
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(ops[start].A, na), hunkRange(ops[start].B, nb))
+
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.Kind)
+			buf.WriteString(op.Line)
+
+			if !strings.HasSuffix(op.Line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return buf.String()
+}
+
+func stringChange(from string, to string) *StringChange {
+	if from == to {
+		return nil
+	}
+
+	return &StringChange{From: from, To: to}
+}
+
+func defaultProgram(doc *Document) string {
+	for _, p := range doc.Programs {
+		if p.IsDefault {
+			return p.Name
+		}
+	}
+
+	return ""
+}
+
+func diffProgram(from *Program, to *Program) *ProgramDiff {
+	d := &ProgramDiff{
+		Name:     to.Name,
+		Vertex:   UnifiedDiff("a/programs/"+from.Name+".glslv", "b/programs/"+to.Name+".glslv", from.Vertex, to.Vertex),
+		Fragment: UnifiedDiff("a/programs/"+from.Name+".glslf", "b/programs/"+to.Name+".glslf", from.Fragment, to.Fragment),
+	}
+
+	if len(d.Vertex) == 0 && len(d.Fragment) == 0 {
+		return nil
+	}
+
+	return d
+}
+
+func diffPrograms(from *Document, to *Document) ProgramsDiff {
+	ret := ProgramsDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Renamed: []StringChange{},
+		Changed: []ProgramDiff{},
+		Default: stringChange(defaultProgram(from), defaultProgram(to)),
+	}
+
+	var removed, added []*Program
+
+	for i := range from.Programs {
+		p := &from.Programs[i]
+
+		if q := to.Program(p.Name); q != nil {
+			if d := diffProgram(p, q); d != nil {
+				ret.Changed = append(ret.Changed, *d)
+			}
+		} else {
+			removed = append(removed, p)
+		}
+	}
+
+	for i := range to.Programs {
+		if p := &to.Programs[i]; from.Program(p.Name) == nil {
+			added = append(added, p)
+		}
+	}
+
+	// Programs with the same shaders are renamed
+	for i := 0; i < len(removed); i++ {
+		for j, q := range added {
+			if removed[i].Vertex == q.Vertex && removed[i].Fragment == q.Fragment {
+				ret.Renamed = append(ret.Renamed, StringChange{From: removed[i].Name, To: q.Name})
+
+				removed = append(removed[:i], removed[i+1:]...)
+				added = append(added[:j], added[j+1:]...)
+				i--
+				break
+			}
+		}
+	}
+
+	// A single removed and added program is considered to be renamed, and
+	// possibly changed
+	if len(removed) == 1 && len(added) == 1 {
+		ret.Renamed = append(ret.Renamed, StringChange{From: removed[0].Name, To: added[0].Name})
+
+		if d := diffProgram(removed[0], added[0]); d != nil {
+			ret.Changed = append(ret.Changed, *d)
+		}
+
+		removed, added = nil, nil
+	}
+
+	for _, p := range removed {
+		ret.Removed = append(ret.Removed, p.Name)
+	}
+
+	for _, p := range added {
+		ret.Added = append(ret.Added, p.Name)
+	}
+
+	return ret
+}
+
+func diffAuthors(from []Author, to []Author) AuthorsDiff {
+	common := 0
+
+	for common < len(from) && common < len(to) && from[common] == to[common] {
+		common++
+	}
+
+	return AuthorsDiff{
+		Removed: append([]Author{}, from[common:]...),
+		Added:   append([]Author{}, to[common:]...),
+	}
+}
+
+// DiffDocuments returns the structured diff between two documents
+func DiffDocuments(fromHash string, from *Document, toHash string, to *Document) *DocumentDiff {
+	return &DocumentDiff{
+		From:        fromHash,
+		To:          toHash,
+		Title:       stringChange(from.Title, to.Title),
+		Description: stringChange(from.Description, to.Description),
+		Programs:    diffPrograms(from, to),
+		Javascript:  UnifiedDiff("a/script.js", "b/script.js", from.Javascript, to.Javascript),
+		Authors:     diffAuthors(from.Authors, to.Authors),
+	}
+}
+
+func (d DiffHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	from, err := LoadSharedDocument(req, vars["a"])
+
+	if err != nil {
+		d.RespondError(writer, err)
+		return
+	}
+
+	to, err := LoadSharedDocument(req, vars["b"])
+
+	if err != nil {
+		d.RespondError(writer, err)
+		return
+	}
+
+	d.RespondJSON(writer, DiffDocuments(vars["a"], from, vars["b"], to))
+}
+
+func init() {
+	router.Handle("/d/{a:[A-Za-z0-9]+}/diff/{b:[A-Za-z0-9]+}", MakeHandler(DiffHandler{}, WrapCompress|WrapCORS))
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	numbers := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"changed", "a\nb\nc\n", "a\nx\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added", "", "a\n", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+		{"removed", "a\nb\n", "a\n", "--- a\n+++ b\n@@ -1,2 +1,1 @@\n a\n-b\n"},
+		{"newline", "a", "a\n", "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		{
+			"hunks",
+			numbers,
+			strings.Replace(strings.Replace(numbers, "1\n", "x\n", 1), "10\n", "y\n", 1),
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+		{
+			"merged",
+			numbers,
+			strings.Replace(strings.Replace(numbers, "2\n", "x\n", 1), "8\n", "y\n", 1),
+			"--- a\n+++ b\n@@ -1,10 +1,10 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n 9\n 10\n",
+		},
+	}
+
+	for _, test := range tests {
+		if got := UnifiedDiff("a", "b", test.from, test.to); got != test.expected {
+			t.Errorf("%s: expected\n%s\nbut got\n%s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := func(prefix string, n int) []string {
+		ret := make([]string, n)
+
+		for i := range ret {
+			ret[i] = fmt.Sprintf("%s%d\n", prefix, i)
+		}
+
+		return ret
+	}
+
+	long := lines("", 10000)
+	edited := append([]string{}, long...)
+	edited[10] = "x\n"
+	edited = append(edited[:5000], edited[5001:]...)
+	edited = append(edited, "y\n")
+
+	// Replacing every other line takes more edits than are searched for, so
+	// all lines but the common last line are replaced
+	alternating := lines("", 2*maxDiffEdits)
+
+	for i := 0; i < len(alternating); i += 2 {
+		alternating[i] = "x\n"
+	}
+
+	tests := []struct {
+		name  string
+		a     []string
+		b     []string
+		edits int
+	}{
+		{"empty", nil, nil, 0},
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, 0},
+		{"added", nil, []string{"a", "b"}, 2},
+		{"removed", []string{"a", "b"}, nil, 2},
+		{"replaced", []string{"a"}, []string{"b"}, 2},
+		{"moved", []string{"a", "b", "c", "d"}, []string{"b", "c", "d", "a"}, 2},
+		{"interleaved", []string{"a", "b", "c", "a", "b", "b", "a"}, []string{"c", "b", "a", "b", "a", "c"}, 5},
+		{"long", long, edited, 4},
+		{"different", lines("a", maxDiffEdits), lines("b", maxDiffEdits), 2 * maxDiffEdits},
+		{"too many edits", lines("", 2*maxDiffEdits), alternating, 4*maxDiffEdits - 2},
+	}
+
+	for _, test := range tests {
+		x, y, edits := 0, 0, 0
+
+		for _, op := range diffLines(test.a, test.b) {
+			if op.A != x || op.B != y {
+				t.Fatalf("%s: expected op at %d,%d, got %v", test.name, x, y, op)
+			}
+
+			switch op.Kind {
+			case ' ':
+				if test.a[x] != test.b[y] {
+					t.Fatalf("%s: expected equal lines at %d,%d", test.name, x, y)
+				}
+
+				x++
+				y++
+			case '-':
+				x++
+				edits++
+			case '+':
+				y++
+				edits++
+			}
+		}
+
+		if x != len(test.a) || y != len(test.b) {
+			t.Errorf("%s: expected the script to cover both texts, got %d,%d", test.name, x, y)
+		}
+
+		if edits != test.edits {
+			t.Errorf("%s: expected %d edits, got %d", test.name, test.edits, edits)
+		}
+	}
+}
+
+func TestDiffPrograms(t *testing.T) {
+	program := func(name string, vertex string, isDefault bool) Program {
+		return Program{Name: name, Vertex: vertex, Fragment: "void main() {}\n", IsDefault: isDefault}
+	}
+
+	tests := []struct {
+		name    string
+		from    []Program
+		to      []Program
+		added   []string
+		removed []string
+		renamed []string
+		changed []string
+		def     string
+	}{
+		{
+			name: "unchanged",
+			from: []Program{program("a", "a\n", true)},
+			to:   []Program{program("a", "a\n", true)},
+		},
+		{
+			name:    "changed",
+			from:    []Program{program("a", "a\n", true)},
+			to:      []Program{program("a", "b\n", true)},
+			changed: []string{"a"},
+		},
+		{
+			name:    "renamed",
+			from:    []Program{program("a", "a\n", true)},
+			to:      []Program{program("b", "b\n", true)},
+			renamed: []string{"a->b"},
+			changed: []string{"b"},
+			def:     "a->b",
+		},
+		{
+			name:    "renamed by content",
+			from:    []Program{program("a", "a\n", true), program("b", "b\n", false)},
+			to:      []Program{program("c", "a\n", true), program("d", "d\n", false), program("e", "e\n", false)},
+			added:   []string{"d", "e"},
+			removed: []string{"b"},
+			renamed: []string{"a->c"},
+			def:     "a->c",
+		},
+		{
+			name: "default",
+			from: []Program{program("a", "a\n", true), program("b", "b\n", false)},
+			to:   []Program{program("a", "a\n", false), program("b", "b\n", true)},
+			def:  "a->b",
+		},
+	}
+
+	check := func(name string, what string, got []string, expected []string) {
+		if strings.Join(got, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: expected %s %q, but got %q", name, what, expected, got)
+		}
+	}
+
+	for _, test := range tests {
+		d := diffPrograms(&Document{Programs: test.from}, &Document{Programs: test.to})
+
+		var renamed, changed []string
+
+		for _, r := range d.Renamed {
+			renamed = append(renamed, r.From+"->"+r.To)
+		}
+
+		for _, c := range d.Changed {
+			changed = append(changed, c.Name)
+		}
+
+		def := ""
+
+		if d.Default != nil {
+			def = d.Default.From + "->" + d.Default.To
+		}
+
+		check(test.name, "added", d.Added, test.added)
+		check(test.name, "removed", d.Removed, test.removed)
+		check(test.name, "renamed", renamed, test.renamed)
+		check(test.name, "changed", changed, test.changed)
+
+		if def != test.def {
+			t.Errorf("%s: expected default %q, but got %q", test.name, test.def, def)
+		}
+	}
+}
+
+func TestDiffAuthors(t *testing.T) {
+	a := Author{Name: "A", License: "CC BY", Year: 2014}
+	b := Author{Name: "B", License: "CC BY", Year: 2015}
+	c := Author{Name: "C", License: "CC BY", Year: 2015}
+
+	tests := []struct {
+		from    []Author
+		to      []Author
+		removed int
+		added   int
+	}{
+		{[]Author{a}, []Author{a}, 0, 0},
+		{[]Author{a}, []Author{a, b}, 0, 1},
+		{[]Author{a, b}, []Author{a, c}, 1, 1},
+		{[]Author{a, b}, nil, 2, 0},
+	}
+
+	for i, test := range tests {
+		d := diffAuthors(test.from, test.to)
+
+		if len(d.Removed) != test.removed || len(d.Added) != test.added {
+			t.Errorf("%d: expected %d removed and %d added, but got %+v", i, test.removed, test.added, d)
+		}
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	setupTest(t)
+
+	doc := newTestDocument()
+	from := storeTestDocument(t, doc)
+
+	doc = newTestDocument()
+	doc.Title = "Changed"
+	doc.Javascript += "c.x = 1;\n"
+	to := storeTestDocument(t, doc)
+
+	w := serveTest(t, "GET", "/d/"+from+"/diff/"+to, nil, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var d DocumentDiff
+
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.From != from || d.To != to {
+		t.Errorf("expected diff from %s to %s, but got %s to %s", from, to, d.From, d.To)
+	}
+
+	if d.Title == nil || d.Title.To != "Changed" {
+		t.Errorf("expected title change, but got %+v", d.Title)
+	}
+
+	if !strings.Contains(d.Javascript, "+c.x = 1;\n") {
+		t.Errorf("expected javascript diff, but got %q", d.Javascript)
+	}
+
+	if d.Description != nil || len(d.Programs.Changed) != 0 {
+		t.Errorf("unexpected changes %+v", d)
+	}
+
+	if w := serveTest(t, "GET", "/d/"+from+"/diff/0000", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown document, but got %d", http.StatusNotFound, w.Code)
+	}
+}
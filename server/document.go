@@ -31,23 +31,20 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 )
 
-var DocumentStorage = Storage{
+var DocumentStorage = &Storage{
 	Directory:   "documents",
 	ContentType: "application/json",
-}
-
-var validLicenses = map[string]bool{
-	"CC 0":        true,
-	"CC BY":       true,
-	"CC BY-NC":    true,
-	"CC BY-SA":    true,
-	"CC BY-NC-SA": true,
+	Check:       checkDocument,
+	Upgrade:     upgradeDocumentData,
+	Upgraded:    documentUpgraded,
+	Access:      documentRequestAccess,
+	Encodings:   []Encoding{GzipEncoding},
 }
 
 type NewDocumentHandler struct {
@@ -76,66 +73,197 @@ type Document struct {
 	Javascript   string    `json:"javascript"`
 	CreationTime time.Time `json:"creationTime"`
 	Authors      []Author  `json:"authors"`
+
+	// Parent is the hash of the document this document was forked from
+	Parent string `json:"parent,omitempty"`
+
+	// Assets are the image assets used by the document
+	Assets []DocumentAsset `json:"assets,omitempty"`
+
+	// pendingAssets holds the data of assets which have not been stored
+	// yet, keyed by hash. They are stored along with the document, so that
+	// documents which are rejected do not leave assets behind.
+	pendingAssets map[string][]byte
 }
 
+// documentJSON has the default JSON decoding of a Document
+type documentJSON Document
+
+// NewDocumentRequest shares a document. Documents are public unless another
+// visibility is given, and never expire unless an expiry time is given.
 type NewDocumentRequest struct {
-	Document Document `json:"document"`
-	Author   string   `json:"author"`
-	License  string   `json:"license"`
+	Document   Document   `json:"document"`
+	Author     string     `json:"author"`
+	License    string     `json:"license"`
+	Visibility string     `json:"visibility,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
 }
 
+// NewDocumentResponse describes a shared document. Key is the key with which
+// private documents can be read.
 type NewDocumentResponse struct {
-	Hash    string   `json:"hash"`
-	Authors []Author `json:"authors"`
+	Hash       string     `json:"hash"`
+	Authors    []Author   `json:"authors"`
+	Visibility string     `json:"visibility"`
+	Key        string     `json:"key,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+func newDocumentResponse(hash string, doc *Document, share DocumentShare) NewDocumentResponse {
+	ret := NewDocumentResponse{
+		Hash:       hash,
+		Authors:    doc.Authors,
+		Visibility: share.Visibility,
+		Key:        share.Key,
+	}
+
+	if !share.Expires.IsZero() {
+		ret.Expires = &share.Expires
+	}
+
+	return ret
+}
+
+// ShaderError is the error of a program with a shader which does not compile
+type ShaderError struct {
+	Program     string     `json:"program"`
+	Shader      string     `json:"shader"`
+	Diagnostics GLSLErrors `json:"diagnostics"`
+}
+
+func (e *ShaderError) Error() string {
+	return fmt.Sprintf("Failed to compile %s shader of program %s:\n%s", e.Shader, e.Program, e.Diagnostics.Error())
+}
+
+// UnmarshalJSON decodes a document, upgrading it to the current schema
+func (d *Document) UnmarshalJSON(data []byte) error {
+	data, _, err := UpgradeDocument(data)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, (*documentJSON)(d))
 }
 
+// Validate checks the program. Fields of errors are relative to the program.
 func (p *Program) Validate() error {
 	if len(p.Name) == 0 {
-		return errors.New("Program does not have a name")
+		return MissingError("name", "Program does not have a name")
 	}
 
 	if len(p.Vertex) == 0 {
-		return errors.New("Program does not have a vertex shader")
+		return MissingError("vertex", "Program does not have a vertex shader")
 	}
 
 	if len(p.Fragment) == 0 {
-		return errors.New("Program does not have a fragment shader")
+		return MissingError("fragment", "Program does not have a fragment shader")
+	}
+
+	if err := p.compile(p.Vertex, GLSLVertexShader); err != nil {
+		return err
+	}
+
+	return p.compile(p.Fragment, GLSLFragmentShader)
+}
+
+// compile compiles a shader of the program. Shaders which do not compile are
+// reported with the ShaderError in the details, so that clients can point at
+// the locations of the diagnostics in the shader source.
+func (p *Program) compile(source string, typ GLSLShaderType) error {
+	if _, err := ParseGLSL(source, typ); err != nil {
+		diagnostics, ok := err.(GLSLErrors)
+
+		if !ok {
+			return err
+		}
+
+		serr := &ShaderError{
+			Program:     p.Name,
+			Shader:      typ.String(),
+			Diagnostics: diagnostics,
+		}
+
+		aerr := InvalidError(ErrorShader, typ.String(), "%s", serr.Error())
+		aerr.Details = serr
+
+		return aerr
+	}
+
+	return nil
+}
+
+// Program returns the program with the given name, or nil if the document
+// does not have such a program
+func (d *Document) Program(name string) *Program {
+	for i := range d.Programs {
+		if d.Programs[i].Name == name {
+			return &d.Programs[i]
+		}
 	}
 
 	return nil
 }
 
+// Validate checks the document. Fields of errors are relative to the request
+// in which the document is sent.
 func (d *Document) Validate() error {
 	if len(d.Title) == 0 {
-		return errors.New("Document does not have a title")
+		return MissingError("document.title", "Document does not have a title")
 	}
 
 	if len(d.Programs) == 0 {
-		return errors.New("Document does not have any programs")
+		return MissingError("document.programs", "Document does not have any programs")
 	}
 
 	hasDefault := false
 
-	for _, p := range d.Programs {
+	for i, p := range d.Programs {
+		path := fmt.Sprintf("document.programs[%d]", i)
+
 		if p.IsDefault {
 			if hasDefault {
-				return errors.New("Only one program can be the default program")
+				return InvalidError(ErrorInvalidField, path+".isDefault", "Only one program can be the default program")
 			}
 
 			hasDefault = true
 		}
 
 		if err := p.Validate(); err != nil {
-			return err
+			return nestError(err, path)
 		}
 	}
 
 	if !hasDefault {
-		return errors.New("Default program not specified")
+		return MissingError("document.programs", "Default program not specified")
 	}
 
 	if len(d.Programs) == 0 {
-		return errors.New("Document does not have a javascript source")
+		return MissingError("document.javascript", "Document does not have a javascript source")
+	}
+
+	return d.validateAssets()
+}
+
+func (d *Document) validateAssets() error {
+	if len(d.Assets) > maxDocumentAssets {
+		return InvalidError(ErrorInvalidField, "document.assets", "Document has more than %d assets", maxDocumentAssets)
+	}
+
+	names := make(map[string]bool)
+
+	for i, a := range d.Assets {
+		path := fmt.Sprintf("document.assets[%d]", i)
+
+		if names[a.Name] {
+			return InvalidError(ErrorInvalidField, path+".name", "Duplicate asset name %s", a.Name)
+		}
+
+		names[a.Name] = true
+
+		if err := a.Validate(d.pendingAssets); err != nil {
+			return nestError(err, path)
+		}
 	}
 
 	return nil
@@ -147,56 +275,194 @@ func (d *Document) ValidatePublication() error {
 	}
 
 	if len(d.Description) == 0 {
-		return errors.New("Document does not have a description")
+		return MissingError("document.description", "Document does not have a description")
 	}
 
 	return nil
 }
 
+// Validate checks the author sharing a document. Fields of errors are
+// relative to the request in which the author and license are sent.
 func (a *Author) Validate() error {
-	if !validLicenses[a.License] {
-		return errors.New("Invalid license")
+	license := LookupLicense(a.License)
+
+	if license == nil {
+		return InvalidError(ErrorInvalidLicense, "license", "Invalid license")
 	}
 
-	if len(a.Name) == 0 && a.License != "CC 0" {
-		return errors.New("License requires author")
+	if len(a.Name) == 0 && license.Attribution {
+		return InvalidError(ErrorAuthorRequired, "author", "License requires author")
 	}
 
 	return nil
 }
 
-func (d *Document) Prepare(a Author) error {
-	if err := d.Validate(); err != nil {
+// resolveParent checks that the parent of the document exists and can be
+// read with the given keys, and refers to it by its current hash in case it
+// has been rekeyed. Parents which cannot be read are reported as not
+// existing, so that forks do not reveal private documents.
+func (d *Document) resolveParent(keys []string) error {
+	if !hasher.ValidHash(d.Parent) {
+		return InvalidError(ErrorInvalidField, "document.parent", "Invalid parent document")
+	}
+
+	_, hash, err := DocumentStorage.read(d.Parent, "")
+
+	if os.IsNotExist(err) {
+		return InvalidError(ErrorParentNotFound, "document.parent", "Parent document %s does not exist", d.Parent)
+	}
+
+	if err != nil {
 		return err
 	}
 
-	if err := a.Validate(); err != nil {
+	if _, err := documentAccess(keys, hash); err != nil {
+		if aerr, ok := err.(*APIError); ok && aerr.Code == ErrorNotFound {
+			return InvalidError(ErrorParentNotFound, "document.parent", "Parent document %s does not exist", d.Parent)
+		}
+
 		return err
 	}
 
-	if len(d.Authors) != 0 {
-		last := d.Authors[len(d.Authors)-1]
+	d.Parent = hash
+	return nil
+}
 
-		if (last.License == "CC BY-SA" || last.License == "CC BY-NC-SA") && a.License != last.License {
-			return fmt.Errorf("Cannot change license from %s to %s", last.License, a.License)
+// Prepare validates the document and appends the author to its author
+// chain, unless they are its last author already. The license of an appended
+// author has to respect the licenses of all earlier authors. The parent of
+// the document has to be readable with the given keys.
+func (d *Document) Prepare(a Author, keys []string) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	if len(d.Parent) != 0 {
+		if err := d.resolveParent(keys); err != nil {
+			return err
 		}
 	}
 
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
 	if len(d.Authors) == 0 || d.Authors[len(d.Authors)-1] != a {
+		if err := CheckLicense(d.Authors, a); err != nil {
+			return err
+		}
+
 		d.Authors = append(d.Authors, a)
 	}
 
 	return nil
 }
 
+// Store stores the document along with its pending assets, and records the
+// assets it references so that they are not collected as garbage
 func (d *Document) Store() (string, error) {
+	hash, _, err := d.store()
+	return hash, err
+}
+
+// store stores the document, and returns its hash and whether an identical
+// document was stored before
+func (d *Document) store() (string, bool, error) {
 	data, err := json.Marshal(d)
 
+	if err != nil {
+		return "", false, err
+	}
+
+	existed, err := DocumentStorage.Exists(hasher.Hash(data))
+
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, a := range d.Assets {
+		if data, ok := d.pendingAssets[a.Hash]; ok {
+			if _, err := AssetsStorage.Store(data); err != nil {
+				return "", false, err
+			}
+		}
+	}
+
+	d.pendingAssets = nil
+
+	hash, err := DocumentStorage.Store(data)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, a := range d.Assets {
+		if err := db.AddAssetReference(hash, a.Hash); err != nil {
+			return "", false, err
+		}
+	}
+
+	return hash, existed, nil
+}
+
+// StoreNew stores a newly shared document, and records how it was shared and
+// that it is a fork of its parent
+func (d *Document) StoreNew(share DocumentShare) (string, error) {
+	hash, existed, err := d.store()
+
 	if err != nil {
 		return "", err
 	}
 
-	return DocumentStorage.Store(data)
+	if err := db.AddDocumentShare(hash, share, existed); err != nil {
+		return "", err
+	}
+
+	if len(d.Parent) != 0 {
+		if err := db.AddFork(hash, d.Parent); err != nil {
+			return "", err
+		}
+	}
+
+	return hash, nil
+}
+
+func checkDocument(data []byte) error {
+	var doc Document
+	return json.Unmarshal(data, &doc)
+}
+
+func upgradeDocumentData(data []byte) ([]byte, bool, error) {
+	if _, upgraded, err := UpgradeDocument(data); err != nil || !upgraded {
+		return data, false, err
+	}
+
+	var doc Document
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+
+	data, err := json.Marshal(doc)
+	return data, true, err
+}
+
+// LoadDocument reads the document with the given hash, upgraded to the
+// current schema
+func LoadDocument(hash string) (*Document, error) {
+	data, err := DocumentStorage.Read(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var doc Document
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
 }
 
 func (d NewDocumentHandler) Post(writer http.ResponseWriter, req *http.Request) {
@@ -207,7 +473,7 @@ func (d NewDocumentHandler) Post(writer http.ResponseWriter, req *http.Request)
 	var ureq NewDocumentRequest
 
 	if err := dec.Decode(&ureq); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		d.RespondError(writer, InvalidError(ErrorInvalidRequest, "", "%s", err.Error()))
 		return
 	}
 
@@ -219,22 +485,29 @@ func (d NewDocumentHandler) Post(writer http.ResponseWriter, req *http.Request)
 		Year:    time.Now().Year(),
 	}
 
-	if err := doc.Prepare(author); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+	share, err := NewDocumentShare(ureq.Visibility, ureq.Expires)
+
+	if err != nil {
+		d.RespondError(writer, err)
+		return
+	}
+
+	if err := doc.Prepare(author, requestKeys(req)); err != nil {
+		d.RespondError(writer, err)
 		return
 	}
 
-	hash, err := doc.Store()
+	hash, err := doc.StoreNew(share)
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		d.RespondError(writer, err)
 		return
 	}
 
-	d.RespondJSON(writer, NewDocumentResponse{Hash: hash, Authors: doc.Authors})
+	d.RespondJSON(writer, newDocumentResponse(hash, &doc, share))
 }
 
 func init() {
 	router.Handle("/d/new", MakeHandler(NewDocumentHandler{}, WrapCORS))
-	router.Handle("/d/{id:[A-Za-z0-9]+}.json", MakeHandler(DocumentStorage, WrapCompress|WrapCORS))
+	router.Handle("/d/{id:[A-Za-z0-9]+}.json", MakeHandler(DocumentStorage, WrapCORS))
 }
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoding describes a compressed variant in which blobs can be stored next
+// to their uncompressed data, so that they can be served precompressed
+type Encoding struct {
+	// The name of the encoding as used in Accept-Encoding and Content-Encoding
+	Name string
+
+	// The extension appended to the blob name to store the variant at
+	Extension string
+
+	Compress   func(data []byte) ([]byte, error)
+	Decompress func(data []byte) ([]byte, error)
+}
+
+var GzipEncoding = Encoding{
+	Name:      "gzip",
+	Extension: ".gz",
+
+	Compress:   gzipCompress,
+	Decompress: gzipDecompress,
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func gzipDecompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header of req allows
+// for a response in the given encoding. As in RFC 7231, section 5.3.4, the
+// quality of the encoding itself takes precedence over that of "*", wherever
+// they occur in the header, and a quality of 0 means not acceptable.
+func acceptsEncoding(req *http.Request, name string) bool {
+	quality := -1.0
+	wildcard := -1.0
+
+	for _, v := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(v, ";")
+		enc := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		if enc != name && enc != "*" {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+
+			if strings.HasPrefix(param, "q=") {
+				var err error
+
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if enc == name {
+			quality = q
+		} else {
+			wildcard = q
+		}
+	}
+
+	if quality < 0 {
+		quality = wildcard
+	}
+
+	return quality > 0
+}
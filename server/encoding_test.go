@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		accepted bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip", true},
+		{"deflate", false},
+		{"gzip;q=0.5", true},
+		{"gzip; q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip;q=invalid", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"br, *;q=0.1", true},
+		{"gzip, *;q=0", true},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"*;q=0, gzip", true},
+		{"identity", false},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+
+		if len(test.header) != 0 {
+			req.Header.Set("Accept-Encoding", test.header)
+		}
+
+		if accepted := acceptsEncoding(req, "gzip"); accepted != test.accepted {
+			t.Errorf("%q: expected accepted %v, got %v", test.header, test.accepted, accepted)
+		}
+	}
+}
+
+func TestGzipEncoding(t *testing.T) {
+	data := bytes.Repeat([]byte("precision mediump float;\n"), 100)
+
+	compressed, err := GzipEncoding.Compress(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compressed) >= len(data) {
+		t.Errorf("expected compressed data to be smaller, got %d >= %d", len(compressed), len(data))
+	}
+
+	if decompressed, err := GzipEncoding.Decompress(compressed); err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("expected data to round trip, got %v", err)
+	}
+
+	if _, err := GzipEncoding.Decompress(data); err == nil {
+		t.Errorf("expected error decompressing uncompressed data")
+	}
+}
+
+func TestStorageEncodings(t *testing.T) {
+	setupTest(t)
+
+	data := []byte(`{"version":1}`)
+	hash := storeTestBlob(t, DocumentStorage, string(data))
+
+	if _, err := DocumentStorage.Backend.Read(DocumentStorage.HashPath(hash) + GzipEncoding.Extension); err != nil {
+		t.Fatalf("expected the gzip variant to be stored: %v", err)
+	}
+
+	// Variants missing for blobs stored before encodings existed are created
+	// when first read
+	DocumentStorage.Backend.Delete(DocumentStorage.HashPath(hash) + GzipEncoding.Extension)
+
+	encoded, found, err := DocumentStorage.readEncoded(hash, GzipEncoding)
+
+	if err != nil || found != hash {
+		t.Fatalf("unexpected encoded read: %s, %v", found, err)
+	}
+
+	if decoded, err := GzipEncoding.Decompress(encoded); err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("expected encoded variant to decode to the blob, got %v", err)
+	}
+
+	if _, err := DocumentStorage.Backend.Read(DocumentStorage.HashPath(hash) + GzipEncoding.Extension); err != nil {
+		t.Errorf("expected the gzip variant to be stored when read: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Blobs are first written to a temporary file which is then renamed into
+// place, so that a crash never leaves a partially written blob behind
+const tempPrefix = ".tmp-"
+
+// Temporary files older than this are left over from a crash, since no
+// request runs long enough to still be writing them
+const maxTempAge = time.Hour
+
+// FileBackend stores blobs as files below a root directory on the local
+// filesystem.
+type FileBackend struct {
+	Root string
+}
+
+func NewFileBackend(root string) *FileBackend {
+	return &FileBackend{
+		Root: root,
+	}
+}
+
+func (f *FileBackend) FullPath(name string) string {
+	return path.Join(f.Root, name)
+}
+
+func syncDir(d string) error {
+	fd, err := os.Open(d)
+
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+	return fd.Sync()
+}
+
+// missingDirs returns d and its parents which do not exist yet, up to the
+// first one which does
+func missingDirs(d string) []string {
+	var ret []string
+
+	for ; d != path.Dir(d); d = path.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+
+		ret = append(ret, d)
+	}
+
+	return ret
+}
+
+func (f *FileBackend) mkdir(d string) error {
+	if _, err := os.Stat(d); err == nil {
+		return nil
+	}
+
+	created := missingDirs(d)
+
+	if err := os.MkdirAll(d, 0755); err != nil {
+		return err
+	}
+
+	// Make sure the new directory entries themselves are persisted, in the
+	// directories containing them
+	for _, p := range created {
+		if err := syncDir(path.Dir(p)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileBackend) Create(name string, data []byte) error {
+	p := f.FullPath(name)
+	d := path.Dir(p)
+
+	if _, err := os.Lstat(p); err == nil {
+		now := time.Now()
+
+		if err := os.Chtimes(p, now, now); err != nil {
+			return err
+		}
+
+		return &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
+	}
+
+	if err := f.mkdir(d); err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(d, tempPrefix)
+
+	if err != nil {
+		return err
+	}
+
+	tmp := fd.Name()
+
+	defer func() {
+		if len(tmp) != 0 {
+			fd.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err := fd.Write(data); err != nil {
+		return err
+	}
+
+	if err := fd.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+
+	tmp = ""
+	return syncDir(d)
+}
+
+func (f *FileBackend) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(f.FullPath(name))
+}
+
+func (f *FileBackend) Exists(name string) (bool, error) {
+	if _, err := os.Stat(f.FullPath(name)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (f *FileBackend) Delete(name string) error {
+	return os.Remove(f.FullPath(name))
+}
+
+func (f *FileBackend) walk(fn func(p string, info os.FileInfo) error) error {
+	// Nothing has been stored yet
+	if _, err := os.Stat(f.Root); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(f.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return fn(p, info)
+	})
+}
+
+func (f *FileBackend) List(fn func(info BlobInfo) error) error {
+	return f.walk(func(p string, info os.FileInfo) error {
+		if strings.HasPrefix(info.Name(), tempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.Root, p)
+
+		if err != nil {
+			return err
+		}
+
+		return fn(BlobInfo{
+			Name:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	})
+}
+
+// RemoveTemporary removes temporary files left over from blobs which were
+// being written when the server crashed. It returns the number of removed
+// files.
+func (f *FileBackend) RemoveTemporary() (int, error) {
+	n := 0
+	now := time.Now()
+
+	err := f.walk(func(p string, info os.FileInfo) error {
+		if !strings.HasPrefix(info.Name(), tempPrefix) || now.Sub(info.ModTime()) < maxTempAge {
+			return nil
+		}
+
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	return n, err
+}
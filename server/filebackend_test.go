@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileBackend(t *testing.T) {
+	testBlobBackend(t, NewFileBackend(path.Join(t.TempDir(), "documents")))
+}
+
+func TestMissingDirs(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(path.Join(root, "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir      string
+		expected []string
+	}{
+		{"ab", nil},
+		{"ab/cd", []string{"ab/cd"}},
+		{"ab/cd/ef", []string{"ab/cd/ef", "ab/cd"}},
+		{"gh/ij/kl", []string{"gh/ij/kl", "gh/ij", "gh"}},
+	}
+
+	for _, test := range tests {
+		var expected []string
+
+		for _, d := range test.expected {
+			expected = append(expected, path.Join(root, d))
+		}
+
+		if dirs := missingDirs(path.Join(root, test.dir)); strings.Join(dirs, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.dir, expected, dirs)
+		}
+	}
+
+	// Creating a blob creates all of its directories
+	backend := NewFileBackend(path.Join(root, "documents"))
+
+	if err := backend.Create("ab/cd/abcdef", []byte("abcdef")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dirs := missingDirs(backend.FullPath("ab/cd")); len(dirs) != 0 {
+		t.Errorf("expected all directories to be created, got %v", dirs)
+	}
+}
+
+func TestFileBackendRemoveTemporary(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+
+	if err := backend.Create("ab/abc", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := backend.FullPath("ab/" + tempPrefix + "stale")
+	fresh := backend.FullPath("ab/" + tempPrefix + "fresh")
+
+	for _, p := range []string{stale, fresh} {
+		if err := ioutil.WriteFile(p, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * maxTempAge)
+
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := backend.RemoveTemporary(); err != nil || n != 1 {
+		t.Fatalf("expected 1 removed file, got %d, %v", n, err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected stale temporary file to be removed")
+	}
+
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("expected recent temporary file to be kept: %v", err)
+	}
+
+	// Temporary files are never listed as blobs
+	n := 0
+
+	backend.List(func(info BlobInfo) error {
+		n++
+		return nil
+	})
+
+	if n != 1 {
+		t.Errorf("expected 1 listed blob, got %d", n)
+	}
+}
+
+func TestFileBackendCreateLeavesNoTemporary(t *testing.T) {
+	backend := NewFileBackend(t.TempDir())
+
+	if err := backend.Create("ab/abc", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Create("ab/abc", []byte("other")); !os.IsExist(err) {
+		t.Fatalf("expected existing blob error, got %v", err)
+	}
+
+	files, err := ioutil.ReadDir(backend.FullPath("ab"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "abc" {
+		t.Errorf("expected only the blob to be written, got %v", files)
+	}
+
+	// Existing blobs are never overwritten
+	if data, err := backend.Read("ab/abc"); err != nil || string(data) != "abc" {
+		t.Errorf("unexpected blob contents %q, %v", data, err)
+	}
+}
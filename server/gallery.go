@@ -32,6 +32,7 @@ package main
 import (
 	"bytes"
 	"crypto/sha1"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -47,6 +48,12 @@ import (
 const DefaultGalleryLimit = 10
 const MaximumGalleryLimit = 50
 
+// MaximumTags limits the number of tags of a gallery item
+const MaximumTags = 8
+
+// MaximumTagLength limits the length of a tag
+const MaximumTagLength = 32
+
 type NewGalleryHandler struct {
 	RestishVoid
 }
@@ -59,6 +66,10 @@ type GalleryHandler struct {
 	RestishVoid
 }
 
+type GalleryTagsHandler struct {
+	RestishVoid
+}
+
 type TokenRequest struct {
 	Email  string `json:"email"`
 	Title  string `json:"title"`
@@ -73,17 +84,17 @@ func (g NewGalleryHandler) Post(writer http.ResponseWriter, req *http.Request) {
 	var treq TokenRequest
 
 	if err := dec.Decode(&treq); err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		g.RespondError(writer, InvalidError(ErrorInvalidRequest, "", "%s", err.Error()))
 		return
 	}
 
 	if !strings.ContainsRune(treq.Email, '@') {
-		http.Error(writer, "Invalid e-mail address", http.StatusInternalServerError)
+		g.RespondError(writer, InvalidError(ErrorInvalidField, "email", "Invalid e-mail address"))
 		return
 	}
 
 	if len(treq.Title) == 0 {
-		http.Error(writer, "Empty title specified", http.StatusInternalServerError)
+		g.RespondError(writer, MissingError("title", "Empty title specified"))
 		return
 	}
 
@@ -95,7 +106,7 @@ func (g NewGalleryHandler) Post(writer http.ResponseWriter, req *http.Request) {
 	tok, err := db.NewRequest()
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		g.RespondError(writer, err)
 		return
 	}
 
@@ -124,7 +135,7 @@ func (g NewGalleryHandler) Post(writer http.ResponseWriter, req *http.Request) {
 
 	if err := emailer.Template.Execute(b, info); err != nil {
 		db.DeleteRequest(tok)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		g.RespondError(writer, err)
 		return
 	}
 
@@ -143,6 +154,7 @@ type UpdateGalleryRequest struct {
 	Screenshot  string   `json:"screenshot"`
 	Description string   `json:"description"`
 	Token       string   `json:"token"`
+	Tags        []string `json:"tags"`
 }
 
 type UpdateGalleryResponse struct {
@@ -150,6 +162,50 @@ type UpdateGalleryResponse struct {
 	Document  Document    `json:"document"`
 }
 
+// normalizeTag returns the canonical form of a tag, or an empty string if it
+// is not a valid tag. Tags consist of lower case letters, digits and dashes.
+func normalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+
+	if len(tag) == 0 || len(tag) > MaximumTagLength {
+		return ""
+	}
+
+	for _, c := range tag {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return ""
+		}
+	}
+
+	return tag
+}
+
+// normalizeTags validates and normalizes the tags of a gallery item,
+// removing duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	ret := make([]string, 0, len(tags))
+
+	for i, tag := range tags {
+		normalized := normalizeTag(tag)
+
+		if len(normalized) == 0 {
+			return nil, InvalidError(ErrorInvalidField, fmt.Sprintf("tags[%d]", i), "Invalid tag %s, tags consist of at most %d letters, digits and dashes", tag, MaximumTagLength)
+		}
+
+		if !seen[normalized] {
+			seen[normalized] = true
+			ret = append(ret, normalized)
+		}
+	}
+
+	if len(ret) > MaximumTags {
+		return nil, InvalidError(ErrorInvalidField, "tags", "Items can have at most %d tags", MaximumTags)
+	}
+
+	return ret, nil
+}
+
 func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
@@ -158,38 +214,45 @@ func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request
 	var ureq UpdateGalleryRequest
 
 	if err := dec.Decode(&ureq); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		g.RespondError(writer, InvalidError(ErrorInvalidRequest, "", "%s", err.Error()))
 		return
 	}
 
 	if len(ureq.Token) == 0 {
-		http.Error(writer, "Invalid token", http.StatusBadRequest)
+		g.RespondError(writer, InvalidError(ErrorInvalidToken, "token", "Invalid token"))
 		return
 	}
 
 	scprefix := "data:image/png;base64,"
 
 	if !strings.HasPrefix(ureq.Screenshot, scprefix) {
-		http.Error(writer, "Invalid screenshot, expected "+scprefix+"...", http.StatusBadRequest)
+		g.RespondError(writer, InvalidError(ErrorInvalidScreenshot, "screenshot", "Invalid screenshot, expected %s...", scprefix))
 		return
 	}
 
 	screenshotData, err := base64.StdEncoding.DecodeString(ureq.Screenshot[len(scprefix):])
 
 	if err != nil {
-		http.Error(writer, fmt.Sprintf("Failed to decode screenshot: %v", err), http.StatusBadRequest)
+		g.RespondError(writer, InvalidError(ErrorInvalidScreenshot, "screenshot", "Failed to decode screenshot: %v", err))
 		return
 	}
 
 	if _, err := png.DecodeConfig(bytes.NewReader(screenshotData)); err != nil {
-		http.Error(writer, fmt.Sprintf("Invalid screenshot: %v", err), http.StatusBadRequest)
+		g.RespondError(writer, InvalidError(ErrorInvalidScreenshot, "screenshot", "Invalid screenshot: %v", err))
+		return
+	}
+
+	tags, err := normalizeTags(ureq.Tags)
+
+	if err != nil {
+		g.RespondError(writer, err)
 		return
 	}
 
 	doc := ureq.Document
 
 	if err := doc.ValidatePublication(); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		g.RespondError(writer, err)
 		return
 	}
 
@@ -199,8 +262,8 @@ func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request
 		Year:    time.Now().Year(),
 	}
 
-	if err := doc.Prepare(author); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+	if err := doc.Prepare(author, requestKeys(req)); err != nil {
+		g.RespondError(writer, err)
 		return
 	}
 
@@ -208,10 +271,27 @@ func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request
 	hash, err := doc.Store()
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		g.RespondError(writer, err)
+		return
+	}
+
+	// Published documents are public, even when they were shared otherwise.
+	// Documents without shares are public already, and are not kept around
+	// as shared once their item has been deleted.
+	shares, err := db.DocumentShares(hash)
+
+	if err != nil {
+		g.RespondError(writer, err)
 		return
 	}
 
+	if len(shares) != 0 {
+		if err := db.AddDocumentShare(hash, DocumentShare{Visibility: VisibilityPublic}, false); err != nil {
+			g.RespondError(writer, err)
+			return
+		}
+	}
+
 	// Then create the actual Gallery
 	item := &GalleryItem{
 		Token:       ureq.Token,
@@ -220,10 +300,16 @@ func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request
 		Description: doc.Description,
 		Author:      author.Name,
 		License:     author.License,
+		Tags:        tags,
 	}
 
-	if err := db.PutGallery(item, screenshotData); err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if err := db.PutGallery(item, &doc, screenshotData); err != nil {
+		// Only tokens of publishing requests have a gallery item
+		if err == sql.ErrNoRows {
+			err = InvalidError(ErrorInvalidToken, "token", "Invalid token")
+		}
+
+		g.RespondError(writer, err)
 		return
 	}
 
@@ -233,18 +319,25 @@ func (g UpdateGalleryHandler) Post(writer http.ResponseWriter, req *http.Request
 	})
 }
 
+// GalleryResponse is a page of a gallery listing. Next is the cursor from
+// which the listing continues, and is empty after the last page.
+type GalleryResponse struct {
+	Items []*GalleryItem `json:"items"`
+	Next  string         `json:"next,omitempty"`
+}
+
 func (g GalleryHandler) Get(writer http.ResponseWriter, req *http.Request) {
-	form := req.Form
+	form := req.URL.Query()
 
 	page, err := strconv.ParseInt(form.Get("page"), 10, 32)
 
-	if err != nil {
+	if err != nil || page < 0 {
 		page = 0
 	}
 
 	limit, err := strconv.ParseInt(form.Get("limit"), 10, 32)
 
-	if err != nil {
+	if err != nil || limit <= 0 {
 		limit = DefaultGalleryLimit
 	}
 
@@ -252,24 +345,82 @@ func (g GalleryHandler) Get(writer http.ResponseWriter, req *http.Request) {
 		limit = MaximumGalleryLimit
 	}
 
+	search := strings.TrimSpace(form.Get("q"))
 	sort := form.Get("sort")
 
-	if sort != "views" {
+	// Search results are ranked by relevance unless sorted otherwise
+	if len(sort) == 0 && len(search) != 0 {
+		sort = "relevance"
+	}
+
+	if sort != "views" && sort != "trending" && (sort != "relevance" || len(search) == 0) {
 		sort = "newest"
 	}
 
-	reversedOrder := form.Get("order") == "reverse"
+	query := GalleryQuery{
+		Page:     int(page),
+		Limit:    int(limit),
+		Sort:     sort,
+		Reversed: form.Get("order") == "reverse",
+		Search:   search,
+	}
+
+	// Items are filtered by all given tags. Tags which are not valid can not
+	// match any item.
+	for _, tag := range form["tag"] {
+		query.Tags = append(query.Tags, strings.ToLower(strings.TrimSpace(tag)))
+	}
+
+	// Listings are a plain list of items unless a cursor is given. An empty
+	// cursor starts a listing which can be continued.
+	_, paged := form["cursor"]
+
+	// A cursor continues a listing with the same sort and order
+	if c := form.Get("cursor"); len(c) != 0 {
+		cursor, err := ParseGalleryCursor(c)
 
-	ret, err := db.Gallery(int(page), int(limit), sort, reversedOrder)
+		if err != nil || cursor.Sort != query.Sort || cursor.Reversed != query.Reversed {
+			g.RespondError(writer, InvalidError(ErrorInvalidField, "cursor", "Invalid gallery cursor"))
+			return
+		}
+
+		query.After = cursor
+	}
+
+	items, next, err := db.Gallery(query)
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		g.RespondError(writer, err)
+		return
+	}
+
+	if !paged {
+		g.RespondJSON(writer, items)
 		return
 	}
 
+	ret := GalleryResponse{
+		Items: items,
+	}
+
+	if next != nil {
+		ret.Next = next.String()
+	}
+
 	g.RespondJSON(writer, ret)
 }
 
+func (g GalleryTagsHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	tags, err := db.GalleryTags()
+
+	if err != nil {
+		g.RespondError(writer, err)
+		return
+	}
+
+	g.RespondJSON(writer, tags)
+}
+
 type ViewGalleryHandler struct {
 	RestishVoid
 }
@@ -296,14 +447,14 @@ func (g ViewGalleryHandler) Post(wr http.ResponseWriter, req *http.Request) {
 	parentNum, err := strconv.ParseInt(parent, 10, 32)
 
 	if err != nil {
-		http.Error(wr, "Invalid parent", http.StatusBadRequest)
+		g.RespondError(wr, InvalidError(ErrorInvalidField, "parent", "Invalid parent"))
 		return
 	}
 
 	idNum, err := strconv.ParseInt(id, 10, 32)
 
 	if err != nil {
-		http.Error(wr, "Invalid id", http.StatusBadRequest)
+		g.RespondError(wr, InvalidError(ErrorInvalidField, "id", "Invalid id"))
 		return
 	}
 
@@ -332,6 +483,7 @@ func (g ViewGalleryHandler) Post(wr http.ResponseWriter, req *http.Request) {
 func init() {
 	router.Handle("/g", MakeHandler(GalleryHandler{}, WrapCompress|WrapCORS))
 	router.Handle("/g/new", MakeHandler(NewGalleryHandler{}, WrapCORS))
+	router.Handle("/g/tags", MakeHandler(GalleryTagsHandler{}, WrapCompress|WrapCORS))
 	router.Handle("/g/update", MakeHandler(UpdateGalleryHandler{}, WrapCORS))
 	router.Handle("/g/{parent:[0-9]+}/{id:[0-9]+}/view", MakeHandler(ViewGalleryHandler{}, WrapCORS))
 }
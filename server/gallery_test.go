@@ -0,0 +1,694 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// publishTestItem publishes a gallery item with the given title and tags. An
+// empty token publishes a new item, and the token of a published item
+// publishes a revision of it.
+func publishTestItem(t *testing.T, token string, title string, tags ...string) *GalleryItem {
+	t.Helper()
+
+	doc := newTestDocument()
+	doc.Title = title
+
+	return publishTestDocument(t, token, doc, tags...)
+}
+
+// publishTestDocument publishes doc like publishTestItem
+func publishTestDocument(t *testing.T, token string, doc *Document, tags ...string) *GalleryItem {
+	t.Helper()
+
+	if len(token) == 0 {
+		tok, err := db.NewRequest()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token = tok
+	}
+
+	hash := storeTestDocument(t, doc)
+
+	item := &GalleryItem{
+		Token:       token,
+		Document:    hash,
+		Title:       doc.Title,
+		Description: doc.Description,
+		Author:      doc.Authors[0].Name,
+		License:     doc.Authors[0].License,
+		Tags:        tags,
+	}
+
+	if err := db.PutGallery(item, doc, testPNG(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	return item
+}
+
+// galleryTitles returns the titles of gallery items, joined by commas
+func galleryTitles(items []*GalleryItem) string {
+	titles := make([]string, len(items))
+
+	for i, item := range items {
+		titles[i] = item.Title
+	}
+
+	return strings.Join(titles, ",")
+}
+
+func TestNormalizeTags(t *testing.T) {
+	long := strings.Repeat("a", MaximumTagLength)
+
+	tests := []struct {
+		tags     []string
+		expected string
+		field    string
+	}{
+		{nil, "", ""},
+		{[]string{"webgl", "noise-3d"}, "webgl,noise-3d", ""},
+		{[]string{" WebGL ", "webgl", "Noise"}, "webgl,noise", ""},
+		{[]string{long}, long, ""},
+		{[]string{long + "a"}, "", "tags[0]"},
+		{[]string{"webgl", ""}, "", "tags[1]"},
+		{[]string{"webgl", "ray marching"}, "", "tags[1]"},
+		{[]string{"shader_toy"}, "", "tags[0]"},
+		{[]string{"élan"}, "", "tags[0]"},
+		{[]string{"a", "b", "c", "d", "e", "f", "g", "h"}, "a,b,c,d,e,f,g,h", ""},
+		{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "A"}, "a,b,c,d,e,f,g,h", ""},
+		{[]string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, "", "tags"},
+	}
+
+	for _, tt := range tests {
+		tags, err := normalizeTags(tt.tags)
+
+		if len(tt.field) != 0 {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorInvalidField || aerr.Field != tt.field {
+				t.Errorf("%v: expected invalid %s, got %v", tt.tags, tt.field, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.tags, err)
+		} else if joined := strings.Join(tags, ","); joined != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.tags, tt.expected, joined)
+		}
+	}
+}
+
+func TestGalleryTagFilter(t *testing.T) {
+	setupTest(t)
+
+	a := publishTestItem(t, "", "A", "webgl", "noise")
+	publishTestItem(t, "", "B", "noise")
+	publishTestItem(t, "", "C")
+
+	// Tags of revisions which have been replaced are not listed
+	publishTestItem(t, a.Token, "A2", "webgl", "fractal")
+
+	tests := []struct {
+		tags     []string
+		expected string
+	}{
+		{nil, "A2,C,B"},
+		{[]string{"noise"}, "B"},
+		{[]string{"webgl"}, "A2"},
+		{[]string{"webgl", "fractal"}, "A2"},
+		{[]string{"webgl", "noise"}, ""},
+		{[]string{"unknown"}, ""},
+	}
+
+	for _, tt := range tests {
+		items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "newest", Tags: tt.tags})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if titles := galleryTitles(items); titles != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.tags, tt.expected, titles)
+		}
+	}
+
+	items, _, _ := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "newest", Tags: []string{"webgl"}})
+
+	if len(items) != 1 || strings.Join(items[0].Tags, ",") != "fractal,webgl" {
+		t.Errorf("expected the tags of the listed item, got %v", items)
+	}
+}
+
+func TestGalleryTagsHandler(t *testing.T) {
+	setupTest(t)
+
+	rec := serveTest(t, "GET", "/g/tags", nil, nil)
+
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("expected no tags, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	a := publishTestItem(t, "", "A", "webgl", "noise")
+	publishTestItem(t, "", "B", "noise", "fractal")
+	publishTestItem(t, a.Token, "A2", "noise")
+
+	rec = serveTest(t, "GET", "/g/tags", nil, nil)
+
+	var counts []TagCount
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, c := range counts {
+		names = append(names, c.Tag+":"+strconv.Itoa(c.Count))
+	}
+
+	if joined := strings.Join(names, ","); joined != "noise:2,fractal:1" {
+		t.Errorf("expected tag counts of published items, got %s", joined)
+	}
+
+	rec = serveTest(t, "GET", "/g?page=0&tag=noise&tag=Fractal", nil, nil)
+
+	var items []*GalleryItem
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	if titles := galleryTitles(items); titles != "B" {
+		t.Errorf("expected items with all tags, got %s", titles)
+	}
+}
+
+func TestUpdateGalleryTags(t *testing.T) {
+	setupTest(t)
+
+	screenshot := "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(t))
+
+	tests := []struct {
+		tags     []string
+		expected string
+		field    string
+	}{
+		{[]string{"WebGL", "noise", "webgl"}, "noise,webgl", ""},
+		{[]string{"webgl", "ray marching"}, "", "tags[1]"},
+	}
+
+	for _, tt := range tests {
+		token, err := db.NewRequest()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := json.Marshal(UpdateGalleryRequest{
+			Document:   *newTestDocument(),
+			Author:     "Author",
+			License:    "CC BY",
+			Screenshot: screenshot,
+			Token:      token,
+			Tags:       tt.tags,
+		})
+
+		rec := serveTest(t, "POST", "/g/update", nil, bytes.NewReader(body))
+
+		if len(tt.field) != 0 {
+			var resp errorResponse
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Field != tt.field {
+				t.Errorf("%v: expected invalid %s, got %d: %s", tt.tags, tt.field, rec.Code, rec.Body.String())
+			}
+
+			continue
+		}
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%v: expected publishing to succeed, got %d: %s", tt.tags, rec.Code, rec.Body.String())
+		}
+
+		items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "newest"})
+
+		if err != nil || len(items) != 1 || strings.Join(items[0].Tags, ",") != tt.expected {
+			t.Errorf("%v: expected tags %s, got %v, %v", tt.tags, tt.expected, items, err)
+		}
+	}
+}
+
+func TestGallerySearchExpression(t *testing.T) {
+	tests := []struct {
+		search   string
+		expected string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"noise", `"noise"*`},
+		{"  perlin   noise ", `"perlin" "noise"*`},
+		{`say "hi"`, `"say" """hi"""*`},
+		{"ray-marching", `"ray-marching"*`},
+		{"NEAR( x", `"NEAR(" "x"*`},
+		{"- * ( )", ""},
+		{"é", `"é"*`},
+	}
+
+	for _, tt := range tests {
+		if expr := gallerySearchExpression(tt.search); expr != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.search, tt.expected, expr)
+		}
+	}
+}
+
+func TestGallerySnippet(t *testing.T) {
+	tests := []struct {
+		snippet  string
+		expected string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"a \x01match\x02 b", "a <mark>match</mark> b"},
+		{"\x01<b>\x02 & \x01c\x02", "<mark>&lt;b&gt;</mark> &amp; <mark>c</mark>"},
+	}
+
+	for _, tt := range tests {
+		if html := gallerySnippet(tt.snippet); html != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.snippet, tt.expected, html)
+		}
+	}
+}
+
+// publishSearchTestItems publishes items which match the search perlin in
+// their title, description and shaders, and an item which does not match
+func publishSearchTestItems(t *testing.T) *GalleryItem {
+	t.Helper()
+
+	title := newTestDocument()
+	title.Title = "Perlin"
+
+	description := newTestDocument()
+	description.Title = "Clouds"
+	description.Description = "Clouds made of Perlin noise"
+
+	shader := newTestDocument()
+	shader.Title = "Plasma"
+	shader.Programs[0].Fragment = "precision mediump float;\nfloat perlinNoise(vec2 p);\nvoid main() {\n\tgl_FragColor = vec4(1.0);\n}\n"
+
+	other := newTestDocument()
+	other.Title = "Other"
+
+	item := publishTestDocument(t, "", title, "noise")
+	publishTestDocument(t, "", description)
+	publishTestDocument(t, "", shader, "noise")
+	publishTestDocument(t, "", other)
+
+	return item
+}
+
+func TestGallerySearch(t *testing.T) {
+	setupTest(t)
+
+	perlin := publishSearchTestItems(t)
+
+	tests := []struct {
+		search   string
+		sort     string
+		tags     []string
+		expected string
+	}{
+		{"perlin", "relevance", nil, "Perlin,Clouds,Plasma"},
+		{"PERL", "relevance", nil, "Perlin,Clouds,Plasma"},
+		{"perlin clouds", "relevance", nil, "Clouds"},
+		{"perlin", "newest", nil, "Plasma,Clouds,Perlin"},
+		{"perlin", "relevance", []string{"noise"}, "Perlin,Plasma"},
+		{"author", "newest", nil, "Other,Plasma,Clouds,Perlin"},
+		{"fractal", "relevance", nil, ""},
+		{"- *", "relevance", nil, ""},
+	}
+
+	check := func(when string) {
+		for _, tt := range tests {
+			items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: tt.sort, Search: tt.search, Tags: tt.tags})
+
+			if err != nil {
+				t.Errorf("%s: %q: unexpected error: %v", when, tt.search, err)
+			} else if titles := galleryTitles(items); titles != tt.expected {
+				t.Errorf("%s: %q sorted by %s: expected %s, got %s", when, tt.search, tt.sort, tt.expected, titles)
+			}
+		}
+	}
+
+	check("indexed")
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.rebuildGallerySearch(tx); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	check("rebuilt")
+
+	// A revision replaces the published item in the index
+	publishTestItem(t, perlin.Token, "Waves")
+
+	items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "relevance", Search: "perlin"})
+
+	if err != nil || galleryTitles(items) != "Clouds,Plasma" {
+		t.Errorf("expected the revision to replace the item, got %v, %v", items, err)
+	}
+
+	items, _, err = db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "relevance", Search: "waves"})
+
+	if err != nil || galleryTitles(items) != "Waves" {
+		t.Errorf("expected the revision to be indexed, got %v, %v", items, err)
+	}
+}
+
+func TestGallerySearchHandler(t *testing.T) {
+	setupTest(t)
+
+	publishSearchTestItems(t)
+
+	rec := serveTest(t, "GET", "/g?page=0&q=perlin", nil, nil)
+
+	var items []*GalleryItem
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatalf("expected a list of items, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if titles := galleryTitles(items); titles != "Perlin,Clouds,Plasma" {
+		t.Fatalf("expected items by relevance, got %s", titles)
+	}
+
+	snippets := []string{
+		"<mark>Perlin</mark>",
+		"Clouds made of <mark>Perlin</mark> noise",
+		"<mark>perlinNoise</mark>",
+	}
+
+	for i, snippet := range snippets {
+		if !strings.Contains(items[i].Snippet, snippet) {
+			t.Errorf("%s: expected snippet to contain %s, got %s", items[i].Title, snippet, items[i].Snippet)
+		}
+	}
+
+	// Listings which are not searches have no snippets
+	rec = serveTest(t, "GET", "/g?page=0", nil, nil)
+
+	if strings.Contains(rec.Body.String(), "snippet") {
+		t.Errorf("expected no snippets without a search, got %s", rec.Body.String())
+	}
+}
+
+func TestParseGalleryCursor(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		cursor string
+		valid  bool
+	}{
+		{encode(`{"s":"views","k":3,"i":1}`), true},
+		{encode(`{"s":"trending","r":true,"k":0.25,"i":1}`), true},
+		{encode(`{"s":"relevance","k":10,"i":1}`), true},
+		{encode(`{"s":"newest","k":"2014-01-01T00:00:00Z","i":1}`), true},
+		{"not base64!", false},
+		{encode(`not json`), false},
+		{encode(`{"s":"random","k":3,"i":1}`), false},
+		{encode(`{"s":"views","k":"3","i":1}`), false},
+		{encode(`{"s":"relevance","k":-1.5,"i":1}`), false},
+		{encode(`{"s":"relevance","k":-1,"i":1}`), false},
+		{encode(`{"s":"newest","k":3,"i":1}`), false},
+	}
+
+	for _, tt := range tests {
+		cursor, err := ParseGalleryCursor(tt.cursor)
+
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.cursor, tt.valid, err)
+		} else if tt.valid {
+			if parsed, err := ParseGalleryCursor(cursor.String()); err != nil || parsed.Sort != cursor.Sort || parsed.Id != cursor.Id {
+				t.Errorf("%s: expected cursor to round trip, got %v, %v", tt.cursor, parsed, err)
+			}
+		}
+	}
+}
+
+func TestGalleryCursorPagination(t *testing.T) {
+	setupTest(t)
+
+	for i, views := range []int{3, 1, 3, 0, 2} {
+		doc := newTestDocument()
+		doc.Title = string(rune('A' + i))
+		doc.Description = strings.Repeat("noise ", i+1)
+
+		item := publishTestDocument(t, "", doc)
+
+		if _, err := db.Exec("UPDATE gallery SET views = ? WHERE id = ?", views, item.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sort     string
+		reversed bool
+		search   string
+		expected string
+	}{
+		{"newest", false, "", "E,D,C,B,A"},
+		{"newest", true, "", "A,B,C,D,E"},
+		{"views", false, "", "C,A,E,B,D"},
+		{"views", true, "", "D,B,E,A,C"},
+		{"relevance", false, "noise", "E,D,C,B,A"},
+		{"relevance", true, "noise", "A,B,C,D,E"},
+	}
+
+	for _, tt := range tests {
+		query := GalleryQuery{Limit: 2, Sort: tt.sort, Reversed: tt.reversed, Search: tt.search}
+
+		var titles []string
+		pages := 0
+
+		for {
+			items, next, err := db.Gallery(query)
+
+			if err != nil {
+				t.Fatalf("%s: %v", tt.sort, err)
+			}
+
+			titles = append(titles, galleryTitles(items))
+			pages++
+
+			if next == nil || pages > 5 {
+				break
+			}
+
+			// Cursors are passed around encoded
+			if query.After, err = ParseGalleryCursor(next.String()); err != nil {
+				t.Fatalf("%s: %v", tt.sort, err)
+			}
+		}
+
+		if joined := strings.Join(titles, ","); joined != tt.expected || pages != 3 {
+			t.Errorf("%s (reversed %v): expected %s in 3 pages, got %s in %d", tt.sort, tt.reversed, tt.expected, joined, pages)
+		}
+	}
+
+	// Items published after a listing started do not shift it
+	items, next, err := db.Gallery(GalleryQuery{Limit: 2, Sort: "newest"})
+
+	if err != nil || galleryTitles(items) != "E,D" {
+		t.Fatalf("expected the first page, got %v, %v", items, err)
+	}
+
+	publishTestItem(t, "", "F")
+
+	items, _, err = db.Gallery(GalleryQuery{Limit: 2, Sort: "newest", After: next})
+
+	if err != nil || galleryTitles(items) != "C,B" {
+		t.Errorf("expected the listing to continue after the cursor, got %v, %v", items, err)
+	}
+
+	// Cursors only continue listings with the same sort
+	if _, _, err := db.Gallery(GalleryQuery{Limit: 2, Sort: "views", After: next}); err == nil {
+		t.Errorf("expected a cursor of another sort to be rejected")
+	}
+}
+
+func TestGalleryHandlerCursor(t *testing.T) {
+	setupTest(t)
+
+	for _, title := range []string{"A", "B", "C"} {
+		publishTestItem(t, "", title)
+	}
+
+	list := func(url string) []*GalleryItem {
+		t.Helper()
+
+		rec := serveTest(t, "GET", url, nil, nil)
+
+		var items []*GalleryItem
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+			t.Fatalf("%s: expected a list of items, got %d: %s", url, rec.Code, rec.Body.String())
+		}
+
+		return items
+	}
+
+	page := func(url string) GalleryResponse {
+		t.Helper()
+
+		rec := serveTest(t, "GET", url, nil, nil)
+
+		var resp GalleryResponse
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected a page of items, got %d: %s", url, rec.Code, rec.Body.String())
+		}
+
+		return resp
+	}
+
+	if titles := galleryTitles(list("/g")); titles != "C,B,A" {
+		t.Errorf("expected a plain list by default, got %s", titles)
+	}
+
+	if titles := galleryTitles(list("/g?page=1&limit=2")); titles != "A" {
+		t.Errorf("expected a plain list of the page, got %s", titles)
+	}
+
+	first := page("/g?limit=2&cursor=")
+
+	if galleryTitles(first.Items) != "C,B" || len(first.Next) == 0 {
+		t.Fatalf("expected the first page with a cursor, got %s, %q", galleryTitles(first.Items), first.Next)
+	}
+
+	last := page("/g?limit=2&cursor=" + first.Next)
+
+	if galleryTitles(last.Items) != "A" || len(last.Next) != 0 {
+		t.Errorf("expected the last page without a cursor, got %s, %q", galleryTitles(last.Items), last.Next)
+	}
+
+	invalid := []string{
+		"/g?cursor=invalid",
+		"/g?sort=views&cursor=" + first.Next,
+		"/g?order=reverse&cursor=" + first.Next,
+	}
+
+	for _, url := range invalid {
+		rec := serveTest(t, "GET", url, nil, nil)
+
+		var resp errorResponse
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Field != "cursor" {
+			t.Errorf("%s: expected an invalid cursor, got %d: %s", url, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestUpdateGalleryShares(t *testing.T) {
+	setupTest(t)
+
+	screenshot := "data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG(t))
+
+	publish := func(doc Document) UpdateGalleryResponse {
+		t.Helper()
+
+		token, err := db.NewRequest()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, _ := json.Marshal(UpdateGalleryRequest{
+			Document:   doc,
+			Author:     "Author",
+			License:    "CC BY",
+			Screenshot: screenshot,
+			Token:      token,
+		})
+
+		rec := serveTest(t, "POST", "/g/update", nil, bytes.NewReader(body))
+
+		var resp UpdateGalleryResponse
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected publishing to succeed, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		return resp
+	}
+
+	// Documents without shares are public already
+	first := publish(*newTestDocument())
+	hash := first.Published.Document
+
+	if shares, err := db.DocumentShares(hash); err != nil || len(shares) != 0 {
+		t.Errorf("expected the published document to not be shared, got %v, %v", shares, err)
+	}
+
+	private, _ := NewDocumentShare(VisibilityPrivate, nil)
+
+	if err := db.AddDocumentShare(hash, private, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishing a document which was shared otherwise makes it public
+	if second := publish(first.Document); second.Published.Document != hash {
+		t.Fatalf("expected the same document to be published, got %s", second.Published.Document)
+	}
+
+	if restricted, err := documentAccess(nil, hash); err != nil || restricted {
+		t.Errorf("expected the published document to be public, got %v, %v", restricted, err)
+	}
+}
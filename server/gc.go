@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+type GCOptions struct {
+	Interval       time.Duration `long:"gc-interval" description:"Interval at which to collect garbage in the background (disabled when 0)"`
+	GracePeriod    time.Duration `long:"gc-grace-period" description:"Minimum age of an unreferenced document or screenshot before it is collected" default:"24h"`
+	ShareRetention time.Duration `long:"gc-share-retention" description:"Age after which documents which were only shared are collected (kept forever when 0)"`
+}
+
+type GCCommand struct {
+	DryRun bool `short:"n" long:"dry-run" description:"Only report what would be collected"`
+}
+
+// GarbageCollector deletes documents and screenshots which are no longer
+// referenced by any published item in the gallery, or any of its revisions,
+// and assets which are no longer referenced by any document.
+// Documents which were never published are only ever shared by their hash,
+// so they are kept unless a share retention period has been configured. The
+// same holds for documents of deleted items which are still shared.
+//
+// Blobs are only collected once they are older than the grace period, which
+// protects blobs which have been stored but are not yet referenced by a
+// gallery item that is in the process of being published.
+type GarbageCollector struct {
+	Options GCOptions
+	DryRun  bool
+
+	// Report, when not nil, receives a line for every collected blob
+	Report io.Writer
+}
+
+type GCStats struct {
+	Blobs int
+	Bytes int64
+}
+
+func (g *GarbageCollector) Collect() (GCStats, error) {
+	var stats GCStats
+
+	documents, screenshots, err := db.GalleryReferences()
+
+	if err != nil {
+		return stats, err
+	}
+
+	shared, err := db.SharedDocuments(time.Now())
+
+	if err != nil {
+		return stats, err
+	}
+
+	removed, err := g.collect(DocumentStorage, documents, shared, true, &stats)
+
+	if err != nil {
+		return stats, err
+	}
+
+	if _, err := g.collect(ScreenshotsStorage, screenshots, nil, false, &stats); err != nil {
+		return stats, err
+	}
+
+	assets, err := g.assetReferences(removed)
+
+	if err != nil {
+		return stats, err
+	}
+
+	if _, err := g.collect(AssetsStorage, assets, nil, false, &stats); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// assetReferences returns the assets referenced by documents, which are live
+// unless all documents referencing them have been removed. Everything
+// recorded about removed documents is forgotten.
+func (g *GarbageCollector) assetReferences(removed []string) (map[string]bool, error) {
+	refs, err := db.AssetReferences()
+
+	if err != nil {
+		return nil, err
+	}
+
+	isRemoved := make(map[string]bool)
+
+	for _, hash := range removed {
+		isRemoved[hash] = true
+
+		if !g.DryRun {
+			if err := db.RemoveDocument(hash); err != nil {
+				return nil, err
+			}
+
+			if err := db.RemoveDocumentShares(hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ret := make(map[string]bool)
+
+	for asset, documents := range refs {
+		ret[asset] = false
+
+		for _, document := range documents {
+			if !isRemoved[document] {
+				ret[asset] = true
+				break
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// reason returns why the blob described by info is garbage, or an empty
+// string if it should be kept. Shared blobs are kept like shareable blobs
+// which were never referenced, even when they were referenced before.
+func (g *GarbageCollector) reason(info BlobInfo, live bool, referenced bool, shared bool, shareable bool, now time.Time) string {
+	age := now.Sub(info.ModTime)
+
+	if live || age < g.Options.GracePeriod {
+		return ""
+	}
+
+	if referenced && !shared {
+		return "deleted"
+	}
+
+	if !shareable {
+		return "unreferenced"
+	}
+
+	if g.Options.ShareRetention != 0 && age >= g.Options.ShareRetention {
+		return "expired share"
+	}
+
+	return ""
+}
+
+// collect removes the garbage of a storage, and returns the hashes of the
+// removed blobs
+func (g *GarbageCollector) collect(s *Storage, refs map[string]bool, shared map[string]bool, shareable bool, stats *GCStats) ([]string, error) {
+	type garbage struct {
+		hash   string
+		info   BlobInfo
+		reason string
+	}
+
+	var collected []garbage
+
+	now := time.Now()
+
+	err := s.List(func(hash string, info BlobInfo) error {
+		live, referenced := refs[hash]
+
+		if reason := g.reason(info, live, referenced, shared[hash], shareable, now); len(reason) != 0 {
+			collected = append(collected, garbage{hash: hash, info: info, reason: reason})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0, len(collected))
+
+	for _, c := range collected {
+		if !g.DryRun {
+			if err := s.Remove(c.hash); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		removed = append(removed, c.hash)
+
+		if g.Report != nil {
+			fmt.Fprintf(g.Report, "%s/%s\t%d\t%s\n", s.Directory, c.info.Name, c.info.Size, c.reason)
+		}
+
+		stats.Blobs++
+		stats.Bytes += c.info.Size
+	}
+
+	return removed, nil
+}
+
+func (g *GarbageCollector) run() {
+	for {
+		time.Sleep(g.Options.Interval)
+
+		stats, err := g.Collect()
+
+		if err != nil {
+			log.Printf("Failed to collect garbage: %v", err)
+			continue
+		}
+
+		if stats.Blobs != 0 {
+			log.Printf("Collected %d documents, screenshots and assets (%d bytes)", stats.Blobs, stats.Bytes)
+		}
+	}
+}
+
+func (c *GCCommand) Execute(args []string) error {
+	if err := setup(); err != nil {
+		return err
+	}
+
+	collector := GarbageCollector{
+		Options: options.GC,
+		DryRun:  c.DryRun,
+		Report:  os.Stdout,
+	}
+
+	stats, err := collector.Collect()
+
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		fmt.Printf("Would collect %d documents, screenshots and assets (%d bytes)\n", stats.Blobs, stats.Bytes)
+	} else {
+		fmt.Printf("Collected %d documents, screenshots and assets (%d bytes)\n", stats.Blobs, stats.Bytes)
+	}
+
+	return nil
+}
+
+func init() {
+	parser.AddCommand("gc",
+		"Collect unreferenced documents, screenshots and assets",
+		"Deletes documents and screenshots which are not referenced by any published gallery item or revision, and assets which are not referenced by any document.",
+		&GCCommand{})
+}
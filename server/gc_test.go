@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGCReason(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		age        time.Duration
+		live       bool
+		referenced bool
+		shared     bool
+		shareable  bool
+		retention  time.Duration
+		reason     string
+	}{
+		{48 * time.Hour, true, true, false, true, 0, ""},
+		{48 * time.Hour, false, true, false, true, 0, "deleted"},
+		{48 * time.Hour, false, true, true, true, 0, ""},
+		{48 * time.Hour, false, true, true, true, 24 * time.Hour, "expired share"},
+		{48 * time.Hour, false, false, false, false, 0, "unreferenced"},
+		{48 * time.Hour, false, false, false, true, 0, ""},
+		{48 * time.Hour, false, false, true, true, 0, ""},
+		{48 * time.Hour, false, false, false, true, 72 * time.Hour, ""},
+		{48 * time.Hour, false, false, false, true, 24 * time.Hour, "expired share"},
+		{time.Hour, false, true, false, false, 0, ""},
+		{time.Hour, false, false, false, false, 0, ""},
+	}
+
+	for i, test := range tests {
+		g := GarbageCollector{
+			Options: GCOptions{
+				GracePeriod:    24 * time.Hour,
+				ShareRetention: test.retention,
+			},
+		}
+
+		info := BlobInfo{ModTime: now.Add(-test.age)}
+
+		if reason := g.reason(info, test.live, test.referenced, test.shared, test.shareable, now); reason != test.reason {
+			t.Errorf("%d: expected reason %q, got %q", i, test.reason, reason)
+		}
+	}
+}
+
+func storeTestBlob(t *testing.T, s *Storage, data string) string {
+	t.Helper()
+
+	hash, err := s.Store([]byte(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestGCCollect(t *testing.T) {
+	setupTest(t)
+
+	published := storeTestBlob(t, DocumentStorage, "published")
+	revision := storeTestBlob(t, DocumentStorage, "revision")
+	deleted := storeTestBlob(t, DocumentStorage, "deleted")
+	deletedShared := storeTestBlob(t, DocumentStorage, "deleted and shared")
+	deletedExpired := storeTestBlob(t, DocumentStorage, "deleted and expired")
+	shared := storeTestBlob(t, DocumentStorage, "shared")
+
+	// Documents of deleted items which are still shared are kept
+	shares := []struct {
+		hash    string
+		expires time.Time
+	}{
+		{deletedShared, time.Time{}},
+		{deletedExpired, time.Now().Add(-time.Hour)},
+	}
+
+	for _, s := range shares {
+		if err := db.AddDocumentShare(s.hash, DocumentShare{Visibility: VisibilityUnlisted, Expires: s.expires}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	screenshot := storeTestBlob(t, ScreenshotsStorage, "screenshot")
+	unreferenced := storeTestBlob(t, ScreenshotsStorage, "unreferenced")
+
+	rows := []struct {
+		document   string
+		screenshot string
+		state      int
+	}{
+		{published, screenshot, StatePublished},
+		{revision, screenshot, StateRevision},
+		{deleted, screenshot, StateDeleted},
+		{deletedShared, screenshot, StateDeleted},
+		{deletedExpired, screenshot, StateDeleted},
+	}
+
+	for _, row := range rows {
+		if _, err := db.Exec("INSERT INTO gallery (document, screenshot, state) VALUES (?, ?, ?)", row.document, row.screenshot, row.state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists := func(s *Storage, hash string) bool {
+		_, err := s.Read(hash)
+
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		return err == nil
+	}
+
+	dry := GarbageCollector{DryRun: true}
+
+	if stats, err := dry.Collect(); err != nil || stats.Blobs != 3 {
+		t.Fatalf("expected 3 blobs to be collected, got %d, %v", stats.Blobs, err)
+	}
+
+	if !exists(DocumentStorage, deleted) || !exists(ScreenshotsStorage, unreferenced) {
+		t.Fatalf("expected a dry run to not remove anything")
+	}
+
+	g := GarbageCollector{}
+
+	if _, err := g.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		storage *Storage
+		hash    string
+		exists  bool
+	}{
+		{DocumentStorage, published, true},
+		{DocumentStorage, revision, true},
+		{DocumentStorage, deleted, false},
+		{DocumentStorage, deletedShared, true},
+		{DocumentStorage, deletedExpired, false},
+		{DocumentStorage, shared, true},
+		{ScreenshotsStorage, screenshot, true},
+		{ScreenshotsStorage, unreferenced, false},
+	}
+
+	for _, e := range expected {
+		if exists(e.storage, e.hash) != e.exists {
+			t.Errorf("expected %s/%s to exist: %v", e.storage.Directory, e.hash, e.exists)
+		}
+	}
+
+	// Documents which were only shared are collected after the retention
+	g.Options.ShareRetention = time.Nanosecond
+
+	if _, err := g.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(DocumentStorage, shared) || exists(DocumentStorage, deletedShared) || !exists(DocumentStorage, published) {
+		t.Errorf("expected only the shared documents to be collected after the retention")
+	}
+}
+
+func TestGCStoredAgain(t *testing.T) {
+	setupTest(t)
+
+	hash := storeTestBlob(t, ScreenshotsStorage, "screenshot")
+	p := ScreenshotsStorage.Backend.(*FileBackend).FullPath(ScreenshotsStorage.HashPath(hash))
+	old := time.Now().Add(-48 * time.Hour)
+
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Storing an old blob again protects it until it is referenced
+	storeTestBlob(t, ScreenshotsStorage, "screenshot")
+
+	g := GarbageCollector{Options: GCOptions{GracePeriod: 24 * time.Hour}}
+
+	if stats, err := g.Collect(); err != nil || stats.Blobs != 0 {
+		t.Errorf("expected the blob stored again to be kept, got %d, %v", stats.Blobs, err)
+	}
+
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, err := g.Collect(); err != nil || stats.Blobs != 1 {
+		t.Errorf("expected the old blob to be collected, got %d, %v", stats.Blobs, err)
+	}
+}
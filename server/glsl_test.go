@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// glslTestFiles are the fixtures of the javascript GLSL front end, which
+// the server front end is checked against
+const glslTestFiles = "../js/glsl/tests/testfiles"
+
+func readGLSLTestFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(glslTestFiles, name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// firstGLSLTestFileError returns the location of the first error recorded
+// in the .ast fixture of name, if any
+func firstGLSLTestFileError(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(glslTestFiles, name+".ast"))
+
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	var ast struct {
+		Errors []struct {
+			Location string `json:"location"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(data, &ast); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ast.Errors) == 0 {
+		return ""
+	}
+
+	var line, column, endLine, endColumn int
+
+	if _, err := fmt.Sscanf(ast.Errors[0].Location, "(%d.%d-%d.%d)", &line, &column, &endLine, &endColumn); err != nil {
+		t.Fatalf("%s: invalid error location %s", name, ast.Errors[0].Location)
+	}
+
+	return fmt.Sprintf("%d:%d", line, column)
+}
+
+func TestParseGLSLTestFiles(t *testing.T) {
+	tests := []struct {
+		file string
+
+		// diagnostic is the expected first diagnostic, as "line:column: message"
+		diagnostic string
+	}{
+		{"ast.glslv", ""},
+		{"ast_builtin.glslv", ""},
+		// the javascript front end does not check const initializers
+		{"ast_const.glslv", "24:36: const variable b must be initialized"},
+		// the javascript front end does not require main
+		{"ast_decl.glslv", "28:1: missing definition of function main"},
+		{"ast_do.glslv", ""},
+		{"ast_error_expr.glslv", "6:9: expected expression, but got ;"},
+		{"ast_expr.glslv", ""},
+		{"ast_for.glslv", ""},
+		{"ast_func.glslv", ""},
+		{"ast_if.glslv", ""},
+		{"ast_jump.glslf", ""},
+		{"ast_jump.glslv", ""},
+		{"ast_scope.glslv", ""},
+		{"ast_struct.glslv", "7:2: missing definition of function main"},
+		{"ast_while.glslv", ""},
+		{"preprocessor.glslv", ""},
+		// the javascript front end ignores malformed #extension directives
+		{"preprocessor_all.glslv", "4:2: expected #extension name : behavior"},
+		{"preprocessor_all_result.glslv", ""},
+		{"preprocessor_error.glslv", "2:2: #error this is an error"},
+		{"preprocessor_result.glslv", ""},
+	}
+
+	for _, test := range tests {
+		typ := GLSLVertexShader
+
+		if strings.HasSuffix(test.file, ".glslf") {
+			typ = GLSLFragmentShader
+		}
+
+		_, err := ParseGLSL(readGLSLTestFile(t, test.file), typ)
+
+		if test.diagnostic == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.file, err)
+			}
+
+			continue
+		}
+
+		errs, ok := err.(GLSLErrors)
+
+		if !ok || len(errs) == 0 {
+			t.Errorf("%s: expected %q, but got %v", test.file, test.diagnostic, err)
+			continue
+		}
+
+		if s := errs[0].String(); s != test.diagnostic {
+			t.Errorf("%s: expected %q, but got %q", test.file, test.diagnostic, s)
+		}
+
+		if loc := firstGLSLTestFileError(t, test.file); loc != "" && !strings.HasPrefix(test.diagnostic, loc+":") {
+			t.Errorf("%s: expected first error at %s like the javascript front end, but got %q", test.file, loc, test.diagnostic)
+		}
+	}
+}
+
+func glslTokenTexts(tokens []GLSLToken) []string {
+	var ret []string
+
+	for _, tok := range tokens {
+		if tok.Kind != glslEOF {
+			ret = append(ret, tok.Text)
+		}
+	}
+
+	return ret
+}
+
+func TestPreprocessGLSLTestFiles(t *testing.T) {
+	tests := []struct {
+		file   string
+		result string
+	}{
+		{"preprocessor.glslv", "preprocessor_result.glslv"},
+		{"preprocessor_all.glslv", "preprocessor_all_result.glslv"},
+	}
+
+	for _, test := range tests {
+		// the server rejects the malformed #extension directive of the
+		// fixture, which is not what is tested here
+		source := strings.Replace(readGLSLTestFile(t, test.file), "#extension whatever follows", "#extension all : warn", 1)
+
+		tokens, errs := tokenizeGLSL(source)
+
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected error: %v", test.file, errs)
+		}
+
+		output, _, errs := preprocessGLSL(tokens, GLSLVertexShader)
+
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected error: %v", test.file, errs)
+		}
+
+		expected, errs := tokenizeGLSL(readGLSLTestFile(t, test.result))
+
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected error: %v", test.result, errs)
+		}
+
+		if got, want := strings.Join(glslTokenTexts(output), " "), strings.Join(glslTokenTexts(expected), " "); got != want {
+			t.Errorf("%s: expected %q, but got %q", test.file, want, got)
+		}
+	}
+}
+
+func TestTokenizeGLSL(t *testing.T) {
+	tests := []struct {
+		source string
+		tokens []string
+		err    string
+	}{
+		{"a+=b<<=1;", []string{"a", "+=", "b", "<<=", "1", ";"}, ""},
+		{"x /* c */ y // d\nz", []string{"x", "y", "z"}, ""},
+		{"0x1F 017 1.5e3 .5 2.", []string{"0x1F", "017", "1.5e3", ".5", "2."}, ""},
+		{"/* open", nil, "1:1: unterminated comment"},
+		{"a @", nil, "1:3: unexpected character '@'"},
+		{"0x", nil, "1:1: invalid hexadecimal constant"},
+		{"1e", nil, "1:1: missing exponent in floating point constant"},
+		{"09", nil, "1:1: invalid octal constant 09"},
+		{"1u", nil, "1:1: invalid suffix u on constant 1"},
+	}
+
+	for _, test := range tests {
+		tokens, errs := tokenizeGLSL(test.source)
+
+		if test.err != "" {
+			if len(errs) == 0 || errs[0].String() != test.err {
+				t.Errorf("%q: expected error %q, but got %v", test.source, test.err, errs)
+			}
+
+			continue
+		}
+
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected error: %v", test.source, errs)
+			continue
+		}
+
+		if got, want := strings.Join(glslTokenTexts(tokens), " "), strings.Join(test.tokens, " "); got != want {
+			t.Errorf("%q: expected %q, but got %q", test.source, want, got)
+		}
+	}
+}
+
+func TestPreprocessGLSL(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		// macros which are not defined evaluate to 0, so that shaders can
+		// test for macros defined at run time
+		{"#if NUM_LIGHTS > 0\nx\n#endif\ny", "y", ""},
+		{"#if !NUM_LIGHTS\nx\n#endif", "x", ""},
+		{"#define N 2\n#if N > 1\nx\n#else\ny\n#endif", "x", ""},
+		{"#define F(a, b) a + b\nF(1, 2)", "1 + 2", ""},
+		{"#ifdef GL_ES\nx\n#endif", "x", ""},
+		{"#if defined(X) || 1\nx\n#endif", "x", ""},
+		{"#if 1 / 0\n#endif", "", "1:7: division by zero in preprocessor expression"},
+		{"#if 1\n", "", "1:2: unterminated conditional directive"},
+		{"#endif", "", "1:2: #endif without #if"},
+		{"#define GL_X 1", "", "1:9: macro names starting with GL_ or __ are reserved"},
+		{"#foo", "", "1:2: unknown preprocessor directive #foo"},
+		{"#extension all : enable", "", "1:18: behavior enable cannot be used with all"},
+	}
+
+	for _, test := range tests {
+		tokens, errs := tokenizeGLSL(test.source)
+
+		if len(errs) != 0 {
+			t.Fatalf("%q: unexpected error: %v", test.source, errs)
+		}
+
+		output, _, errs := preprocessGLSL(tokens, GLSLVertexShader)
+
+		if test.err != "" {
+			if len(errs) == 0 || errs[0].String() != test.err {
+				t.Errorf("%q: expected error %q, but got %v", test.source, test.err, errs)
+			}
+
+			continue
+		}
+
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected error: %v", test.source, errs)
+			continue
+		}
+
+		if got := strings.Join(glslTokenTexts(output), " "); got != test.output {
+			t.Errorf("%q: expected %q, but got %q", test.source, test.output, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+// GLSLUnit is a parsed shader. Only declarations at global scope are retained
+// since function bodies are merely checked for syntax errors.
+type GLSLUnit struct {
+	Type       GLSLShaderType
+	Extensions []GLSLExtension
+
+	// Precisions are the default precision statements at global scope
+	Precisions []GLSLPrecision
+	Structs    []*GLSLStruct
+	Variables  []*GLSLVariable
+	Functions  []*GLSLFunction
+
+	// Invariants are the names redeclared as invariant at global scope
+	Invariants []string
+}
+
+type GLSLPrecision struct {
+	Precision string `json:"precision"`
+	Type      string `json:"type"`
+}
+
+// GLSLType is a type specifier. Struct is set for struct types, both when
+// the struct is declared in the specifier and when it refers to a struct by
+// name.
+type GLSLType struct {
+	Precision string      `json:"precision,omitempty"`
+	Name      string      `json:"name"`
+	Struct    *GLSLStruct `json:"-"`
+}
+
+type GLSLStruct struct {
+	Name     string
+	Fields   []*GLSLVariable
+	Location GLSLLocation
+}
+
+// GLSLVariable is a declared variable, a struct field or a function
+// parameter. Precision is the precision of the variable, which is either
+// specified in its type or the default precision at its declaration. For
+// arrays, ArraySize contains the source of the constant expression
+// specifying the size.
+type GLSLVariable struct {
+	Qualifier string
+	Invariant bool
+	Type      *GLSLType
+	Precision string
+	Name      string
+	IsArray   bool
+	ArraySize string
+	Location  GLSLLocation
+}
+
+type GLSLFunction struct {
+	ReturnType *GLSLType
+	Name       string
+	Params     []*GLSLVariable
+	Defined    bool
+	Location   GLSLLocation
+}
+
+var glslTypeKeywords = map[string]bool{
+	"void": true, "float": true, "int": true, "bool": true,
+	"vec2": true, "vec3": true, "vec4": true,
+	"bvec2": true, "bvec3": true, "bvec4": true,
+	"ivec2": true, "ivec3": true, "ivec4": true,
+	"mat2": true, "mat3": true, "mat4": true,
+	"sampler2D": true, "samplerCube": true,
+}
+
+var glslKeywords = map[string]bool{
+	"attribute": true, "const": true, "uniform": true, "varying": true,
+	"break": true, "continue": true, "do": true, "for": true, "while": true,
+	"if": true, "else": true, "in": true, "out": true, "inout": true,
+	"true": true, "false": true, "lowp": true, "mediump": true, "highp": true,
+	"precision": true, "invariant": true, "discard": true, "return": true,
+	"struct": true,
+}
+
+var glslReservedKeywords = map[string]bool{
+	"asm": true, "class": true, "union": true, "enum": true, "typedef": true,
+	"template": true, "this": true, "packed": true, "goto": true,
+	"switch": true, "default": true, "inline": true, "noinline": true,
+	"volatile": true, "public": true, "static": true, "extern": true,
+	"external": true, "interface": true, "flat": true, "long": true,
+	"short": true, "double": true, "half": true, "fixed": true,
+	"unsigned": true, "superp": true, "input": true, "output": true,
+	"hvec2": true, "hvec3": true, "hvec4": true, "dvec2": true, "dvec3": true,
+	"dvec4": true, "fvec2": true, "fvec3": true, "fvec4": true,
+	"sampler1D": true, "sampler3D": true, "sampler1DShadow": true,
+	"sampler2DShadow": true, "sampler2DRect": true, "sampler3DRect": true,
+	"sampler2DRectShadow": true, "sizeof": true, "cast": true,
+	"namespace": true, "using": true,
+}
+
+var glslPrecisionQualifiers = map[string]bool{
+	"lowp": true, "mediump": true, "highp": true,
+}
+
+func isGLSLKeyword(name string) bool {
+	return glslKeywords[name] || glslTypeKeywords[name]
+}
+
+// glslBaseType returns the type of the components of a builtin type
+func glslBaseType(name string) string {
+	switch name {
+	case "float", "vec2", "vec3", "vec4", "mat2", "mat3", "mat4":
+		return "float"
+	case "int", "ivec2", "ivec3", "ivec4":
+		return "int"
+	case "bool", "bvec2", "bvec3", "bvec4":
+		return "bool"
+	}
+
+	return name
+}
@@ -0,0 +1,975 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type glslScope struct {
+	structs    map[string]*GLSLStruct
+	precisions map[string]string
+}
+
+type glslParser struct {
+	tokens []GLSLToken
+	pos    int
+	end    GLSLLocation
+
+	unit   *GLSLUnit
+	scopes []glslScope
+}
+
+var glslBinaryPrecedence = map[string]int{
+	"||": 1,
+	"^^": 2,
+	"&&": 3,
+	"|":  4,
+	"^":  5,
+	"&":  6,
+	"==": 7, "!=": 7,
+	"<": 8, ">": 8, "<=": 8, ">=": 8,
+	"<<": 9, ">>": 9,
+	"+": 10, "-": 10,
+	"*": 11, "/": 11, "%": 11,
+}
+
+var glslAssignmentOperators = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true,
+	"%=": true, "<<=": true, ">>=": true, "&=": true, "^=": true, "|=": true,
+}
+
+// glslReservedOperators are the operators which are reserved for future use
+// in GLSL ES 1.0
+var glslReservedOperators = map[string]bool{
+	"%": true, "~": true, "<<": true, ">>": true, "&": true, "|": true, "^": true,
+	"%=": true, "<<=": true, ">>=": true, "&=": true, "^=": true, "|=": true,
+}
+
+// ParseGLSL preprocesses and parses a GLSL ES 1.0.17 shader. It returns
+// GLSLErrors when the shader does not compile. Besides syntax errors, only
+// the few semantic errors which can be detected without type checking are
+// reported.
+func ParseGLSL(source string, typ GLSLShaderType) (*GLSLUnit, error) {
+	tokens, errs := tokenizeGLSL(source)
+	tokens, extensions, perrs := preprocessGLSL(tokens, typ)
+
+	errs = append(errs, perrs...)
+
+	if len(errs) != 0 {
+		sort.Stable(glslErrorsByLocation(errs))
+		return nil, errs
+	}
+
+	p := &glslParser{
+		tokens: tokens,
+		unit: &GLSLUnit{
+			Type:       typ,
+			Extensions: extensions,
+		},
+	}
+
+	if len(tokens) != 0 {
+		p.end = tokens[len(tokens)-1].Location
+	} else {
+		p.end = GLSLLocation{Line: 1, Column: 1}
+	}
+
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+
+	return p.unit, nil
+}
+
+type glslErrorsByLocation GLSLErrors
+
+func (e glslErrorsByLocation) Len() int {
+	return len(e)
+}
+
+func (e glslErrorsByLocation) Less(i, j int) bool {
+	if e[i].Line != e[j].Line {
+		return e[i].Line < e[j].Line
+	}
+
+	return e[i].Column < e[j].Column
+}
+
+func (e glslErrorsByLocation) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+
+func (p *glslParser) parse() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = GLSLErrors{recoverGLSL(r)}
+		}
+	}()
+
+	p.pushScope()
+
+	if p.unit.Type == GLSLFragmentShader {
+		p.setPrecision("int", "mediump")
+	} else {
+		p.setPrecision("float", "highp")
+		p.setPrecision("int", "highp")
+	}
+
+	p.setPrecision("sampler2D", "lowp")
+	p.setPrecision("samplerCube", "lowp")
+
+	for p.peek().Kind != glslEOF {
+		p.externalDeclaration()
+	}
+
+	for _, f := range p.unit.Functions {
+		if f.Name == "main" && f.Defined {
+			return nil
+		}
+	}
+
+	glslErrorf(p.end, "missing definition of function main")
+	return nil
+}
+
+func (p *glslParser) pushScope() {
+	p.scopes = append(p.scopes, glslScope{
+		structs:    make(map[string]*GLSLStruct),
+		precisions: make(map[string]string),
+	})
+}
+
+func (p *glslParser) popScope() {
+	p.scopes = p.scopes[:len(p.scopes)-1]
+}
+
+func (p *glslParser) global() bool {
+	return len(p.scopes) == 1
+}
+
+func (p *glslParser) setPrecision(typ string, precision string) {
+	p.scopes[len(p.scopes)-1].precisions[typ] = precision
+}
+
+func (p *glslParser) defaultPrecision(typ string) string {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if prec, ok := p.scopes[i].precisions[typ]; ok {
+			return prec
+		}
+	}
+
+	return ""
+}
+
+func (p *glslParser) lookupStruct(name string) *GLSLStruct {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if s := p.scopes[i].structs[name]; s != nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (p *glslParser) peekAt(n int) GLSLToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+
+	return GLSLToken{Kind: glslEOF, Location: p.end}
+}
+
+func (p *glslParser) peek() GLSLToken {
+	return p.peekAt(0)
+}
+
+func (p *glslParser) next() GLSLToken {
+	tok := p.peek()
+
+	if tok.Kind != glslEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *glslParser) is(tok GLSLToken, text string) bool {
+	return (tok.Kind == glslIdentifier || tok.Kind == glslOperator) && tok.Text == text
+}
+
+func (p *glslParser) accept(text string) bool {
+	if p.is(p.peek(), text) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// unexpected reports that tok is not any of the expected choices
+func (p *glslParser) unexpected(tok GLSLToken, choices ...string) {
+	got := tok.Text
+
+	if tok.Kind == glslEOF {
+		got = "nothing"
+	}
+
+	if len(choices) > 1 {
+		glslErrorf(tok.Location, "expected one of %s or %s, but got %s", strings.Join(choices[:len(choices)-1], ", "), choices[len(choices)-1], got)
+	}
+
+	glslErrorf(tok.Location, "expected %s, but got %s", choices[0], got)
+}
+
+func (p *glslParser) expect(text string) GLSLToken {
+	tok := p.next()
+
+	if !p.is(tok, text) {
+		p.unexpected(tok, text)
+	}
+
+	return tok
+}
+
+func (p *glslParser) isTypeName(tok GLSLToken) bool {
+	return tok.Kind == glslIdentifier && (glslTypeKeywords[tok.Text] || p.lookupStruct(tok.Text) != nil)
+}
+
+// identifier consumes an identifier which is not a keyword
+func (p *glslParser) identifier() GLSLToken {
+	tok := p.next()
+
+	if tok.Kind != glslIdentifier || isGLSLKeyword(tok.Text) {
+		p.unexpected(tok, "identifier")
+	}
+
+	if glslReservedKeywords[tok.Text] {
+		glslErrorf(tok.Location, "%s is a reserved keyword", tok.Text)
+	}
+
+	return tok
+}
+
+// declarationName consumes the name of a new declaration
+func (p *glslParser) declarationName() GLSLToken {
+	tok := p.identifier()
+
+	if strings.HasPrefix(tok.Text, "gl_") {
+		glslErrorf(tok.Location, "identifier %s is reserved", tok.Text)
+	}
+
+	return tok
+}
+
+// checkPrecision reports float declarations in fragment shaders without a
+// precision, for which there is no default precision
+func (p *glslParser) checkPrecision(typ *GLSLType, loc GLSLLocation) {
+	if len(typ.Precision) == 0 && glslBaseType(typ.Name) == "float" && len(p.defaultPrecision("float")) == 0 {
+		glslErrorf(loc, "no precision specified for %s", typ.Name)
+	}
+}
+
+// precision returns the precision of a variable of the given type
+func (p *glslParser) precision(typ *GLSLType) string {
+	if len(typ.Precision) != 0 {
+		return typ.Precision
+	}
+
+	switch base := glslBaseType(typ.Name); base {
+	case "float", "int", "sampler2D", "samplerCube":
+		return p.defaultPrecision(base)
+	}
+
+	return ""
+}
+
+func (p *glslParser) externalDeclaration() {
+	tok := p.peek()
+
+	switch {
+	case p.is(tok, "precision"):
+		p.precisionStatement()
+		return
+	case p.is(tok, "invariant") && !p.is(p.peekAt(1), "varying"):
+		p.invariantDeclaration()
+		return
+	}
+
+	qualifier, invariant := p.typeQualifier()
+	typ := p.typeSpecifier()
+
+	if p.accept(";") {
+		return
+	}
+
+	name := p.declarationName()
+
+	if p.is(p.peek(), "(") {
+		if len(qualifier) != 0 {
+			glslErrorf(tok.Location, "qualifier %s not allowed on function return type", qualifier)
+		}
+
+		p.function(typ, name)
+		return
+	}
+
+	p.declarators(qualifier, invariant, typ, name)
+}
+
+func (p *glslParser) precisionStatement() {
+	p.expect("precision")
+
+	tok := p.next()
+
+	if !glslPrecisionQualifiers[tok.Text] {
+		p.unexpected(tok, "lowp", "mediump", "highp")
+	}
+
+	typ := p.next()
+
+	switch typ.Text {
+	case "float", "int", "sampler2D", "samplerCube":
+	default:
+		p.unexpected(typ, "float", "int", "sampler2D", "samplerCube")
+	}
+
+	p.expect(";")
+	p.setPrecision(typ.Text, tok.Text)
+
+	if p.global() {
+		p.unit.Precisions = append(p.unit.Precisions, GLSLPrecision{
+			Precision: tok.Text,
+			Type:      typ.Text,
+		})
+	}
+}
+
+func (p *glslParser) invariantDeclaration() {
+	tok := p.expect("invariant")
+
+	if !p.global() {
+		glslErrorf(tok.Location, "invariant is only allowed at global scope")
+	}
+
+	for {
+		p.unit.Invariants = append(p.unit.Invariants, p.identifier().Text)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.expect(";")
+}
+
+// typeQualifier consumes an optional storage qualifier
+func (p *glslParser) typeQualifier() (string, bool) {
+	tok := p.peek()
+	invariant := false
+
+	if p.is(tok, "invariant") {
+		p.next()
+		invariant = true
+
+		if !p.is(p.peek(), "varying") {
+			p.unexpected(p.peek(), "varying")
+		}
+	}
+
+	switch {
+	case p.is(tok, "const"), p.is(tok, "attribute"), p.is(tok, "varying"), p.is(tok, "uniform"), invariant:
+	default:
+		return "", false
+	}
+
+	qualifier := p.next().Text
+
+	if qualifier != "const" && !p.global() {
+		glslErrorf(tok.Location, "%s is only allowed at global scope", tok.Text)
+	}
+
+	if qualifier == "attribute" && p.unit.Type == GLSLFragmentShader {
+		glslErrorf(tok.Location, "attribute is not allowed in fragment shaders")
+	}
+
+	return qualifier, invariant
+}
+
+func (p *glslParser) typeSpecifier() *GLSLType {
+	typ := &GLSLType{}
+
+	if glslPrecisionQualifiers[p.peek().Text] && p.peek().Kind == glslIdentifier {
+		typ.Precision = p.next().Text
+	}
+
+	tok := p.peek()
+
+	switch {
+	case p.is(tok, "struct"):
+		s := p.structSpecifier()
+
+		typ.Name = s.Name
+		typ.Struct = s
+	case p.isTypeName(tok):
+		p.next()
+
+		typ.Name = tok.Text
+		typ.Struct = p.lookupStruct(tok.Text)
+	default:
+		p.unexpected(tok, "type")
+	}
+
+	if len(typ.Precision) != 0 {
+		switch glslBaseType(typ.Name) {
+		case "float", "int", "sampler2D", "samplerCube":
+		default:
+			glslErrorf(tok.Location, "precision qualifier not allowed on type %s", typ.Name)
+		}
+	}
+
+	return typ
+}
+
+func (p *glslParser) structSpecifier() *GLSLStruct {
+	tok := p.expect("struct")
+
+	s := &GLSLStruct{
+		Location: tok.Location,
+	}
+
+	if !p.is(p.peek(), "{") {
+		s.Name = p.declarationName().Text
+	}
+
+	p.expect("{")
+
+	for !p.accept("}") {
+		if p.is(p.peek(), "struct") {
+			glslErrorf(p.peek().Location, "embedded struct definitions are not supported")
+		}
+
+		typ := p.typeSpecifier()
+
+		for {
+			name := p.declarationName()
+			p.checkPrecision(typ, name.Location)
+
+			field := &GLSLVariable{
+				Type:      typ,
+				Precision: p.precision(typ),
+				Name:      name.Text,
+				Location:  name.Location,
+			}
+
+			p.arraySize(field)
+			s.Fields = append(s.Fields, field)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+
+		p.expect(";")
+	}
+
+	if len(s.Fields) == 0 {
+		glslErrorf(tok.Location, "struct must have at least one field")
+	}
+
+	if len(s.Name) != 0 {
+		p.scopes[len(p.scopes)-1].structs[s.Name] = s
+
+		if p.global() {
+			p.unit.Structs = append(p.unit.Structs, s)
+		}
+	}
+
+	return s
+}
+
+// arraySize consumes an optional array size of a declaration
+func (p *glslParser) arraySize(v *GLSLVariable) {
+	if !p.accept("[") {
+		return
+	}
+
+	start := p.pos
+	p.conditionalExpression()
+
+	v.IsArray = true
+	v.ArraySize = joinGLSLTokens(p.tokens[start:p.pos])
+
+	p.expect("]")
+}
+
+func (p *glslParser) declarators(qualifier string, invariant bool, typ *GLSLType, name GLSLToken) {
+	for {
+		p.checkPrecision(typ, name.Location)
+
+		v := &GLSLVariable{
+			Qualifier: qualifier,
+			Invariant: invariant,
+			Type:      typ,
+			Precision: p.precision(typ),
+			Name:      name.Text,
+			Location:  name.Location,
+		}
+
+		if p.is(p.peek(), "[") {
+			p.arraySize(v)
+		} else if p.is(p.peek(), "=") {
+			switch qualifier {
+			case "attribute", "varying", "uniform":
+				glslErrorf(p.peek().Location, "cannot initialize %s variable %s", qualifier, name.Text)
+			}
+
+			p.next()
+			p.assignmentExpression()
+		} else if qualifier == "const" {
+			glslErrorf(name.Location, "const variable %s must be initialized", name.Text)
+		}
+
+		if typ.Name == "void" {
+			glslErrorf(name.Location, "variable %s cannot have type void", name.Text)
+		}
+
+		if p.global() {
+			p.unit.Variables = append(p.unit.Variables, v)
+		}
+
+		if !p.accept(",") {
+			break
+		}
+
+		name = p.declarationName()
+	}
+
+	p.expect(";")
+}
+
+func (p *glslParser) function(typ *GLSLType, name GLSLToken) {
+	f := &GLSLFunction{
+		ReturnType: typ,
+		Name:       name.Text,
+		Location:   name.Location,
+	}
+
+	if typ.Name != "void" {
+		p.checkPrecision(typ, name.Location)
+	}
+
+	p.expect("(")
+
+	// Parameters are declared in the scope of the function body
+	p.pushScope()
+	defer p.popScope()
+
+	if p.is(p.peek(), "void") && p.is(p.peekAt(1), ")") {
+		p.next()
+	} else if !p.is(p.peek(), ")") {
+		for {
+			f.Params = append(f.Params, p.parameter())
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	p.expect(")")
+	p.unit.Functions = append(p.unit.Functions, f)
+
+	if p.accept(";") {
+		return
+	}
+
+	f.Defined = true
+
+	if !p.is(p.peek(), "{") {
+		p.unexpected(p.peek(), ";", "{")
+	}
+
+	p.compoundStatement(false)
+}
+
+func (p *glslParser) parameter() *GLSLVariable {
+	var qualifiers []string
+
+	tok := p.peek()
+
+	if p.accept("const") {
+		qualifiers = append(qualifiers, "const")
+	}
+
+	switch q := p.peek(); {
+	case p.is(q, "in"), p.is(q, "out"), p.is(q, "inout"):
+		p.next()
+
+		if len(qualifiers) != 0 && q.Text != "in" {
+			glslErrorf(tok.Location, "const cannot be used with %s", q.Text)
+		}
+
+		qualifiers = append(qualifiers, q.Text)
+	}
+
+	v := &GLSLVariable{
+		Qualifier: strings.Join(qualifiers, " "),
+		Type:      p.typeSpecifier(),
+		Location:  tok.Location,
+	}
+
+	v.Precision = p.precision(v.Type)
+
+	if v.Type.Name == "void" {
+		glslErrorf(tok.Location, "parameter cannot have type void")
+	}
+
+	if next := p.peek(); !p.is(next, ",") && !p.is(next, ")") && !p.is(next, "[") {
+		name := p.declarationName()
+
+		v.Name = name.Text
+		v.Location = name.Location
+	}
+
+	p.checkPrecision(v.Type, v.Location)
+	p.arraySize(v)
+
+	return v
+}
+
+// isDeclaration returns whether the next statement is a declaration
+func (p *glslParser) isDeclaration() bool {
+	tok := p.peek()
+
+	if tok.Kind != glslIdentifier {
+		return false
+	}
+
+	switch tok.Text {
+	case "const", "attribute", "varying", "uniform", "invariant", "precision", "struct", "lowp", "mediump", "highp":
+		return true
+	}
+
+	// Type names followed by a parenthesis are constructors
+	return p.isTypeName(tok) && !p.is(p.peekAt(1), "(")
+}
+
+func (p *glslParser) declaration() {
+	tok := p.peek()
+
+	if p.is(tok, "precision") {
+		p.precisionStatement()
+		return
+	}
+
+	if p.is(tok, "invariant") && !p.is(p.peekAt(1), "varying") {
+		p.invariantDeclaration()
+		return
+	}
+
+	qualifier, invariant := p.typeQualifier()
+	typ := p.typeSpecifier()
+
+	if p.accept(";") {
+		return
+	}
+
+	name := p.declarationName()
+
+	if p.is(p.peek(), "(") {
+		glslErrorf(name.Location, "functions can only be declared at global scope")
+	}
+
+	p.declarators(qualifier, invariant, typ, name)
+}
+
+func (p *glslParser) compoundStatement(newScope bool) {
+	p.expect("{")
+
+	if newScope {
+		p.pushScope()
+		defer p.popScope()
+	}
+
+	for !p.accept("}") {
+		if p.peek().Kind == glslEOF {
+			p.unexpected(p.peek(), "}")
+		}
+
+		p.statement()
+	}
+}
+
+// scopedStatement parses a statement in a new scope
+func (p *glslParser) scopedStatement() {
+	p.pushScope()
+	defer p.popScope()
+
+	p.statement()
+}
+
+func (p *glslParser) statement() {
+	tok := p.peek()
+
+	switch {
+	case p.is(tok, "{"):
+		p.compoundStatement(true)
+	case p.is(tok, "if"):
+		p.next()
+		p.expect("(")
+		p.expression()
+		p.expect(")")
+		p.scopedStatement()
+
+		if p.accept("else") {
+			p.scopedStatement()
+		}
+	case p.is(tok, "while"):
+		p.next()
+		p.pushScope()
+		p.expect("(")
+		p.condition()
+		p.expect(")")
+		p.statement()
+		p.popScope()
+	case p.is(tok, "do"):
+		p.next()
+		p.scopedStatement()
+		p.expect("while")
+		p.expect("(")
+		p.expression()
+		p.expect(")")
+		p.expect(";")
+	case p.is(tok, "for"):
+		p.next()
+		p.pushScope()
+		p.expect("(")
+
+		if p.isDeclaration() {
+			p.declaration()
+		} else if !p.accept(";") {
+			p.expression()
+			p.expect(";")
+		}
+
+		if !p.accept(";") {
+			p.condition()
+			p.expect(";")
+		}
+
+		if !p.is(p.peek(), ")") {
+			p.expression()
+		}
+
+		p.expect(")")
+		p.statement()
+		p.popScope()
+	case p.is(tok, "discard"):
+		if p.unit.Type != GLSLFragmentShader {
+			glslErrorf(tok.Location, "discard is only allowed in fragment shaders")
+		}
+
+		fallthrough
+	case p.is(tok, "continue"), p.is(tok, "break"):
+		p.next()
+		p.expect(";")
+	case p.is(tok, "return"):
+		p.next()
+
+		if !p.accept(";") {
+			p.expression()
+			p.expect(";")
+		}
+	case p.is(tok, ";"):
+		p.next()
+	case p.isDeclaration():
+		p.declaration()
+	default:
+		p.expression()
+		p.expect(";")
+	}
+}
+
+// condition parses the condition of a while or for loop, which can declare
+// a variable
+func (p *glslParser) condition() {
+	if !p.isDeclaration() {
+		p.expression()
+		return
+	}
+
+	typ := p.typeSpecifier()
+	name := p.declarationName()
+
+	p.checkPrecision(typ, name.Location)
+	p.expect("=")
+	p.assignmentExpression()
+}
+
+func (p *glslParser) expression() {
+	for {
+		p.assignmentExpression()
+
+		if !p.accept(",") {
+			break
+		}
+	}
+}
+
+func (p *glslParser) checkOperator(tok GLSLToken) {
+	if glslReservedOperators[tok.Text] {
+		glslErrorf(tok.Location, "operator %s is reserved", tok.Text)
+	}
+}
+
+func (p *glslParser) assignmentExpression() {
+	p.conditionalExpression()
+
+	if tok := p.peek(); tok.Kind == glslOperator && glslAssignmentOperators[tok.Text] {
+		p.checkOperator(tok)
+		p.next()
+		p.assignmentExpression()
+	}
+}
+
+func (p *glslParser) conditionalExpression() {
+	p.binaryExpression(1)
+
+	if p.accept("?") {
+		p.expression()
+		p.expect(":")
+		p.assignmentExpression()
+	}
+}
+
+// binaryExpression parses a binary expression of operators with at least
+// the given precedence
+func (p *glslParser) binaryExpression(precedence int) {
+	p.unaryExpression()
+
+	for {
+		tok := p.peek()
+		prec, ok := glslBinaryPrecedence[tok.Text]
+
+		if tok.Kind != glslOperator || !ok || prec < precedence {
+			return
+		}
+
+		p.checkOperator(tok)
+		p.next()
+		p.binaryExpression(prec + 1)
+	}
+}
+
+func (p *glslParser) unaryExpression() {
+	tok := p.peek()
+
+	if tok.Kind == glslOperator {
+		switch tok.Text {
+		case "++", "--", "+", "-", "!", "~":
+			p.checkOperator(tok)
+			p.next()
+			p.unaryExpression()
+			return
+		}
+	}
+
+	p.postfixExpression()
+}
+
+func (p *glslParser) postfixExpression() {
+	p.primaryExpression()
+
+	for {
+		switch {
+		case p.accept("["):
+			p.expression()
+			p.expect("]")
+		case p.accept("."):
+			p.identifier()
+		case p.accept("++"), p.accept("--"):
+		default:
+			return
+		}
+	}
+}
+
+func (p *glslParser) primaryExpression() {
+	tok := p.peek()
+
+	switch {
+	case tok.Kind == glslIntConstant, tok.Kind == glslFloatConstant:
+		p.next()
+	case p.is(tok, "true"), p.is(tok, "false"):
+		p.next()
+	case p.is(tok, "("):
+		p.next()
+		p.expression()
+		p.expect(")")
+	case p.isTypeName(tok):
+		// Constructor
+		p.next()
+		p.arguments()
+	case tok.Kind == glslIdentifier && !isGLSLKeyword(tok.Text):
+		p.identifier()
+
+		if p.is(p.peek(), "(") {
+			p.arguments()
+		}
+	default:
+		p.unexpected(tok, "expression")
+	}
+}
+
+func (p *glslParser) arguments() {
+	p.expect("(")
+
+	if p.accept(")") {
+		return
+	}
+
+	if p.is(p.peek(), "void") && p.is(p.peekAt(1), ")") {
+		p.next()
+		p.next()
+		return
+	}
+
+	for {
+		p.assignmentExpression()
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	p.expect(")")
+}
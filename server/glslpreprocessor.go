@@ -0,0 +1,791 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GLSLExtension is an extension requested by an #extension directive
+type GLSLExtension struct {
+	Name     string `json:"name"`
+	Behavior string `json:"behavior"`
+}
+
+type glslMacro struct {
+	Name         string
+	FunctionLike bool
+	Params       []string
+	Body         []GLSLToken
+	Predefined   bool
+}
+
+type glslCondition struct {
+	Location GLSLLocation
+
+	// ParentSkipping is set when the enclosing section is skipped, in which
+	// case none of the branches of the condition are taken
+	ParentSkipping bool
+	Skipping       bool
+	Taken          bool
+	SawElse        bool
+}
+
+// glslPanic is used to bail out of deeply nested parsing on the first error
+type glslPanic struct {
+	GLSLDiagnostic
+}
+
+func glslErrorf(loc GLSLLocation, format string, args ...interface{}) {
+	panic(glslPanic{GLSLDiagnostic{
+		GLSLLocation: loc,
+		Message:      fmt.Sprintf(format, args...),
+	}})
+}
+
+// recoverGLSL recovers from a panic raised by glslErrorf and returns the
+// diagnostic it carried
+func recoverGLSL(r interface{}) GLSLDiagnostic {
+	if p, ok := r.(glslPanic); ok {
+		return p.GLSLDiagnostic
+	}
+
+	panic(r)
+}
+
+type glslPreprocessor struct {
+	defines    map[string]*glslMacro
+	conditions []glslCondition
+
+	// pending contains the tokens since the last directive, which are macro
+	// expanded together so that macro invocations can span multiple lines
+	pending []GLSLToken
+	output  []GLSLToken
+
+	extensions []GLSLExtension
+	errors     GLSLErrors
+	seenTokens bool
+}
+
+func glslIntToken(v int) GLSLToken {
+	return GLSLToken{Kind: glslIntConstant, Text: strconv.Itoa(v)}
+}
+
+// preprocessGLSL runs the preprocessor on the tokens of a GLSL source, as
+// described in section 3.4 of the GLSL ES 1.0.17 specification
+func preprocessGLSL(tokens []GLSLToken, typ GLSLShaderType) ([]GLSLToken, []GLSLExtension, GLSLErrors) {
+	p := &glslPreprocessor{
+		defines: make(map[string]*glslMacro),
+	}
+
+	p.predefine("__LINE__", GLSLToken{})
+	p.predefine("__FILE__", glslIntToken(0))
+	p.predefine("__VERSION__", glslIntToken(100))
+	p.predefine("GL_ES", glslIntToken(1))
+
+	if typ == GLSLFragmentShader {
+		p.predefine("GL_FRAGMENT_PRECISION_HIGH", glslIntToken(1))
+	}
+
+	for i := 0; i < len(tokens); {
+		end := i + 1
+
+		for end < len(tokens) && !tokens[end].StartOfLine {
+			end++
+		}
+
+		line := tokens[i:end]
+
+		if line[0].Text == "#" {
+			p.flush()
+			p.line(line)
+		} else if !p.skipping() {
+			p.pending = append(p.pending, line...)
+		}
+
+		p.seenTokens = true
+		i = end
+	}
+
+	p.flush()
+
+	if len(p.conditions) != 0 {
+		p.errorf(p.conditions[len(p.conditions)-1].Location, "unterminated conditional directive")
+	}
+
+	return p.output, p.extensions, p.errors
+}
+
+func (p *glslPreprocessor) predefine(name string, value GLSLToken) {
+	p.defines[name] = &glslMacro{
+		Name:       name,
+		Body:       []GLSLToken{value},
+		Predefined: true,
+	}
+}
+
+func (p *glslPreprocessor) errorf(loc GLSLLocation, format string, args ...interface{}) {
+	p.errors = append(p.errors, GLSLDiagnostic{
+		GLSLLocation: loc,
+		Message:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *glslPreprocessor) skipping() bool {
+	return len(p.conditions) != 0 && p.conditions[len(p.conditions)-1].Skipping
+}
+
+func (p *glslPreprocessor) flush() {
+	if len(p.pending) == 0 {
+		return
+	}
+
+	p.output = append(p.output, p.expand(p.pending, nil)...)
+	p.pending = nil
+}
+
+// line handles a single directive line, and reports the first error in it
+func (p *glslPreprocessor) line(line []GLSLToken) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.errors = append(p.errors, recoverGLSL(r))
+		}
+	}()
+
+	p.directive(line)
+}
+
+func (p *glslPreprocessor) directive(line []GLSLToken) {
+	// The null directive
+	if len(line) == 1 {
+		return
+	}
+
+	name := line[1]
+	args := line[2:]
+
+	switch name.Text {
+	case "if", "ifdef", "ifndef":
+		p.pushCondition(name, args)
+		return
+	case "elif", "else", "endif":
+		p.continueCondition(name, args)
+		return
+	}
+
+	if p.skipping() {
+		return
+	}
+
+	switch name.Text {
+	case "define":
+		p.define(name, args)
+	case "undef":
+		macro := p.macroName(name, args)
+
+		if len(args) > 1 {
+			glslErrorf(args[1].Location, "unexpected %s after macro name", args[1].Text)
+		}
+
+		if m := p.defines[macro]; m != nil && m.Predefined {
+			glslErrorf(args[0].Location, "cannot undefine predefined macro %s", macro)
+		}
+
+		delete(p.defines, macro)
+	case "error":
+		p.errorf(name.Location, "#error %s", joinGLSLTokens(args))
+	case "pragma":
+	case "extension":
+		p.extension(name, args)
+	case "version":
+		if p.seenTokens {
+			glslErrorf(line[0].Location, "#version must occur before anything else")
+		}
+
+		if len(args) != 1 || args[0].Text != "100" {
+			glslErrorf(name.Location, "unsupported version %s, only version 100 is supported", joinGLSLTokens(args))
+		}
+	case "line":
+		args = p.expand(args, nil)
+
+		if len(args) == 0 || len(args) > 2 {
+			glslErrorf(name.Location, "expected line number and optional source string number")
+		}
+
+		for _, arg := range args {
+			if arg.Kind != glslIntConstant {
+				glslErrorf(arg.Location, "expected integer constant, but got %s", arg.Text)
+			}
+		}
+	default:
+		glslErrorf(name.Location, "unknown preprocessor directive #%s", name.Text)
+	}
+}
+
+func (p *glslPreprocessor) macroName(directive GLSLToken, args []GLSLToken) string {
+	if len(args) == 0 || args[0].Kind != glslIdentifier {
+		glslErrorf(directive.Location, "expected macro name after #%s", directive.Text)
+	}
+
+	return args[0].Text
+}
+
+func (p *glslPreprocessor) pushCondition(directive GLSLToken, args []GLSLToken) {
+	cond := glslCondition{
+		Location:       directive.Location,
+		ParentSkipping: p.skipping(),
+	}
+
+	// Push the condition before evaluating it, so that the section is still
+	// tracked when the expression is invalid
+	p.conditions = append(p.conditions, cond)
+	top := &p.conditions[len(p.conditions)-1]
+
+	if cond.ParentSkipping {
+		top.Skipping = true
+		top.Taken = true
+		return
+	}
+
+	top.Skipping = true
+
+	var taken bool
+
+	if directive.Text == "if" {
+		taken = p.evaluate(directive, args)
+	} else {
+		macro := p.macroName(directive, args)
+
+		if len(args) > 1 {
+			glslErrorf(args[1].Location, "unexpected %s after macro name", args[1].Text)
+		}
+
+		taken = (p.defines[macro] != nil) == (directive.Text == "ifdef")
+	}
+
+	top.Taken = taken
+	top.Skipping = !taken
+}
+
+func (p *glslPreprocessor) continueCondition(directive GLSLToken, args []GLSLToken) {
+	if len(p.conditions) == 0 {
+		glslErrorf(directive.Location, "#%s without #if", directive.Text)
+	}
+
+	top := &p.conditions[len(p.conditions)-1]
+
+	if directive.Text == "endif" {
+		p.conditions = p.conditions[:len(p.conditions)-1]
+	} else if top.SawElse {
+		glslErrorf(directive.Location, "#%s after #else", directive.Text)
+	} else if directive.Text == "else" {
+		top.Skipping = top.ParentSkipping || top.Taken
+		top.Taken = true
+		top.SawElse = true
+	} else if top.ParentSkipping || top.Taken {
+		top.Skipping = true
+	} else {
+		taken := p.evaluate(directive, args)
+
+		top.Taken = taken
+		top.Skipping = !taken
+	}
+
+	if directive.Text != "elif" && len(args) != 0 {
+		glslErrorf(args[0].Location, "unexpected %s after #%s", args[0].Text, directive.Text)
+	}
+}
+
+func (p *glslPreprocessor) define(directive GLSLToken, args []GLSLToken) {
+	name := p.macroName(directive, args)
+	loc := args[0].Location
+
+	if strings.HasPrefix(name, "GL_") || strings.HasPrefix(name, "__") {
+		glslErrorf(loc, "macro names starting with GL_ or __ are reserved")
+	}
+
+	if name == "defined" {
+		glslErrorf(loc, "cannot define macro defined")
+	}
+
+	m := &glslMacro{
+		Name: name,
+	}
+
+	body := args[1:]
+
+	// A function-like macro has its parameter list immediately following the
+	// macro name, without any whitespace in between
+	if len(body) != 0 && body[0].Text == "(" && !body[0].SpaceBefore {
+		m.FunctionLike = true
+		m.Params, body = p.macroParams(body)
+	}
+
+	m.Body = body
+
+	if prev := p.defines[name]; prev != nil && !prev.equal(m) {
+		glslErrorf(loc, "macro %s redefined", name)
+	}
+
+	p.defines[name] = m
+}
+
+func (p *glslPreprocessor) macroParams(tokens []GLSLToken) ([]string, []GLSLToken) {
+	var params []string
+
+	i := 1
+
+	if i < len(tokens) && tokens[i].Text == ")" {
+		return params, tokens[i+1:]
+	}
+
+	for {
+		if i >= len(tokens) || tokens[i].Kind != glslIdentifier {
+			glslErrorf(tokens[i-1].Location, "expected macro parameter name")
+		}
+
+		params = append(params, tokens[i].Text)
+		i++
+
+		if i >= len(tokens) {
+			glslErrorf(tokens[i-1].Location, "expected , or ) in macro parameter list")
+		}
+
+		switch tokens[i].Text {
+		case ")":
+			return params, tokens[i+1:]
+		case ",":
+			i++
+		default:
+			glslErrorf(tokens[i].Location, "expected , or ) in macro parameter list, but got %s", tokens[i].Text)
+		}
+	}
+}
+
+func (m *glslMacro) equal(other *glslMacro) bool {
+	if m.FunctionLike != other.FunctionLike || strings.Join(m.Params, ",") != strings.Join(other.Params, ",") {
+		return false
+	}
+
+	return joinGLSLTokens(m.Body) == joinGLSLTokens(other.Body)
+}
+
+func (p *glslPreprocessor) extension(directive GLSLToken, args []GLSLToken) {
+	if len(args) != 3 || args[0].Kind != glslIdentifier || args[1].Text != ":" || args[2].Kind != glslIdentifier {
+		glslErrorf(directive.Location, "expected #extension name : behavior")
+	}
+
+	name := args[0].Text
+	behavior := args[2].Text
+
+	switch behavior {
+	case "require", "enable":
+		if name == "all" {
+			glslErrorf(args[2].Location, "behavior %s cannot be used with all", behavior)
+		}
+	case "warn", "disable":
+	default:
+		glslErrorf(args[2].Location, "unknown extension behavior %s", behavior)
+	}
+
+	p.extensions = append(p.extensions, GLSLExtension{
+		Name:     name,
+		Behavior: behavior,
+	})
+}
+
+// joinGLSLTokens reconstructs the source text of a list of tokens
+func joinGLSLTokens(tokens []GLSLToken) string {
+	var parts []string
+
+	for i, tok := range tokens {
+		if i != 0 && tok.SpaceBefore {
+			parts = append(parts, " ")
+		}
+
+		parts = append(parts, tok.Text)
+	}
+
+	return strings.Join(parts, "")
+}
+
+// expand macro expands tokens. Macros in hidden are not expanded, which
+// prevents macros from recursively expanding themselves.
+func (p *glslPreprocessor) expand(tokens []GLSLToken, hidden map[string]bool) []GLSLToken {
+	var ret []GLSLToken
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		m := p.defines[tok.Text]
+
+		if tok.Kind != glslIdentifier || m == nil || hidden[tok.Text] {
+			ret = append(ret, tok)
+			continue
+		}
+
+		var body []GLSLToken
+
+		if m.FunctionLike {
+			if i+1 >= len(tokens) || tokens[i+1].Text != "(" {
+				ret = append(ret, tok)
+				continue
+			}
+
+			args, end := p.macroArguments(tokens, i, m)
+
+			if args == nil {
+				return ret
+			}
+
+			body = p.substitute(tok, m, args, hidden)
+			i = end
+		} else if m.Name == "__LINE__" {
+			body = p.relocate(tok, []GLSLToken{glslIntToken(tok.Location.Line)})
+		} else {
+			body = p.relocate(tok, m.Body)
+		}
+
+		h := map[string]bool{m.Name: true}
+
+		for k := range hidden {
+			h[k] = true
+		}
+
+		ret = append(ret, p.expand(body, h)...)
+	}
+
+	return ret
+}
+
+// relocate copies the body of a macro, placing all its tokens at the
+// location of the macro invocation
+func (p *glslPreprocessor) relocate(invocation GLSLToken, body []GLSLToken) []GLSLToken {
+	ret := make([]GLSLToken, len(body))
+
+	for i, tok := range body {
+		tok.Location = invocation.Location
+		tok.StartOfLine = false
+
+		if i == 0 {
+			tok.SpaceBefore = invocation.SpaceBefore
+		}
+
+		ret[i] = tok
+	}
+
+	return ret
+}
+
+// macroArguments collects the arguments of the invocation of the
+// function-like macro at tokens[start]. It returns the index of the closing
+// parenthesis.
+func (p *glslPreprocessor) macroArguments(tokens []GLSLToken, start int, m *glslMacro) ([][]GLSLToken, int) {
+	args := [][]GLSLToken{{}}
+	depth := 0
+
+	for i := start + 2; i < len(tokens); i++ {
+		tok := tokens[i]
+		last := len(args) - 1
+
+		switch {
+		case tok.Text == ")" && depth == 0:
+			if len(m.Params) == 0 && len(args) == 1 && len(args[0]) == 0 {
+				args = args[:0]
+			}
+
+			if len(args) != len(m.Params) {
+				p.errorf(tokens[start].Location, "macro %s expects %d arguments, but got %d", m.Name, len(m.Params), len(args))
+				return nil, i
+			}
+
+			return args, i
+		case tok.Text == "," && depth == 0:
+			args = append(args, []GLSLToken{})
+			continue
+		case tok.Text == "(":
+			depth++
+		case tok.Text == ")":
+			depth--
+		}
+
+		args[last] = append(args[last], tok)
+	}
+
+	p.errorf(tokens[start].Location, "unterminated invocation of macro %s", m.Name)
+	return nil, len(tokens)
+}
+
+// substitute replaces the parameters in the body of a function-like macro
+// with the fully expanded arguments of its invocation
+func (p *glslPreprocessor) substitute(invocation GLSLToken, m *glslMacro, args [][]GLSLToken, hidden map[string]bool) []GLSLToken {
+	body := p.relocate(invocation, m.Body)
+
+	var ret []GLSLToken
+
+	for _, tok := range body {
+		param := -1
+
+		if tok.Kind == glslIdentifier {
+			for i, name := range m.Params {
+				if name == tok.Text {
+					param = i
+					break
+				}
+			}
+		}
+
+		if param < 0 {
+			ret = append(ret, tok)
+		} else {
+			ret = append(ret, p.expand(args[param], hidden)...)
+		}
+	}
+
+	return ret
+}
+
+// evaluate evaluates the constant expression of an #if or #elif directive
+func (p *glslPreprocessor) evaluate(directive GLSLToken, args []GLSLToken) bool {
+	var tokens []GLSLToken
+
+	// The defined operator is applied before macro expansion
+	for i := 0; i < len(args); i++ {
+		tok := args[i]
+
+		if tok.Kind != glslIdentifier || tok.Text != "defined" {
+			tokens = append(tokens, tok)
+			continue
+		}
+
+		paren := i+1 < len(args) && args[i+1].Text == "("
+
+		if paren {
+			i++
+		}
+
+		if i+1 >= len(args) || args[i+1].Kind != glslIdentifier {
+			glslErrorf(tok.Location, "expected macro name after defined")
+		}
+
+		i++
+		name := args[i].Text
+
+		if paren {
+			if i+1 >= len(args) || args[i+1].Text != ")" {
+				glslErrorf(args[i].Location, "expected ) after macro name")
+			}
+
+			i++
+		}
+
+		v := 0
+
+		if p.defines[name] != nil {
+			v = 1
+		}
+
+		lit := glslIntToken(v)
+		lit.Location = tok.Location
+		tokens = append(tokens, lit)
+	}
+
+	e := &glslExpressionEvaluator{
+		tokens: p.expand(tokens, nil),
+		end:    directive.Location,
+	}
+
+	if len(e.tokens) == 0 {
+		glslErrorf(directive.Location, "expected expression after #%s", directive.Text)
+	}
+
+	v := e.expression(1)
+
+	if e.pos < len(e.tokens) {
+		glslErrorf(e.tokens[e.pos].Location, "unexpected %s in preprocessor expression", e.tokens[e.pos].Text)
+	}
+
+	return v != 0
+}
+
+type glslExpressionEvaluator struct {
+	tokens []GLSLToken
+	pos    int
+	end    GLSLLocation
+}
+
+var glslPreprocessorPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, ">": 7, "<=": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+func (e *glslExpressionEvaluator) next() GLSLToken {
+	if e.pos >= len(e.tokens) {
+		glslErrorf(e.end, "unexpected end of preprocessor expression")
+	}
+
+	tok := e.tokens[e.pos]
+	e.pos++
+
+	return tok
+}
+
+// expression evaluates a binary expression of operators with at least the
+// given precedence
+func (e *glslExpressionEvaluator) expression(precedence int) int64 {
+	lhs := e.unary()
+
+	for e.pos < len(e.tokens) {
+		op := e.tokens[e.pos]
+		prec, ok := glslPreprocessorPrecedence[op.Text]
+
+		if op.Kind != glslOperator || !ok || prec < precedence {
+			break
+		}
+
+		e.pos++
+		rhs := e.expression(prec + 1)
+		lhs = e.binary(op, lhs, rhs)
+	}
+
+	return lhs
+}
+
+func glslBool(b bool) int64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (e *glslExpressionEvaluator) binary(op GLSLToken, lhs int64, rhs int64) int64 {
+	switch op.Text {
+	case "||":
+		return glslBool(lhs != 0 || rhs != 0)
+	case "&&":
+		return glslBool(lhs != 0 && rhs != 0)
+	case "|":
+		return lhs | rhs
+	case "^":
+		return lhs ^ rhs
+	case "&":
+		return lhs & rhs
+	case "==":
+		return glslBool(lhs == rhs)
+	case "!=":
+		return glslBool(lhs != rhs)
+	case "<":
+		return glslBool(lhs < rhs)
+	case ">":
+		return glslBool(lhs > rhs)
+	case "<=":
+		return glslBool(lhs <= rhs)
+	case ">=":
+		return glslBool(lhs >= rhs)
+	case "<<", ">>":
+		if rhs < 0 || rhs > 63 {
+			glslErrorf(op.Location, "invalid shift by %d", rhs)
+		}
+
+		if op.Text == "<<" {
+			return lhs << uint(rhs)
+		}
+
+		return lhs >> uint(rhs)
+	case "+":
+		return lhs + rhs
+	case "-":
+		return lhs - rhs
+	case "*":
+		return lhs * rhs
+	}
+
+	if rhs == 0 {
+		glslErrorf(op.Location, "division by zero in preprocessor expression")
+	}
+
+	if op.Text == "/" {
+		return lhs / rhs
+	}
+
+	return lhs % rhs
+}
+
+func (e *glslExpressionEvaluator) unary() int64 {
+	tok := e.next()
+
+	switch {
+	case tok.Kind == glslIntConstant:
+		v, err := strconv.ParseInt(tok.Text, 0, 64)
+
+		if err != nil {
+			glslErrorf(tok.Location, "invalid integer constant %s", tok.Text)
+		}
+
+		return v
+	case tok.Kind == glslFloatConstant:
+		glslErrorf(tok.Location, "floating point constant %s in preprocessor expression", tok.Text)
+	case tok.Kind == glslIdentifier:
+		// Identifiers which are not macros evaluate to 0, which allows
+		// shaders to test for macros which the playground defines at run
+		// time (e.g. #if NUM_LIGHTS > 0)
+		return 0
+	case tok.Text == "(":
+		v := e.expression(1)
+
+		if e.pos >= len(e.tokens) || e.tokens[e.pos].Text != ")" {
+			glslErrorf(tok.Location, "expected ) in preprocessor expression")
+		}
+
+		e.pos++
+		return v
+	case tok.Text == "+":
+		return e.unary()
+	case tok.Text == "-":
+		return -e.unary()
+	case tok.Text == "~":
+		return ^e.unary()
+	case tok.Text == "!":
+		return glslBool(e.unary() == 0)
+	}
+
+	glslErrorf(tok.Location, "unexpected %s in preprocessor expression", tok.Text)
+	return 0
+}
@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// GLSLShaderType is the type of shader a GLSL source is compiled as
+type GLSLShaderType int
+
+const (
+	GLSLVertexShader GLSLShaderType = iota
+	GLSLFragmentShader
+)
+
+func (t GLSLShaderType) String() string {
+	if t == GLSLFragmentShader {
+		return "fragment"
+	}
+
+	return "vertex"
+}
+
+// GLSLLocation is a 1-based position in a GLSL source
+type GLSLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GLSLDiagnostic is a problem found in a GLSL source
+type GLSLDiagnostic struct {
+	GLSLLocation
+	Message string `json:"message"`
+}
+
+func (d GLSLDiagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// GLSLErrors is the list of diagnostics of a GLSL source which failed to
+// compile
+type GLSLErrors []GLSLDiagnostic
+
+func (e GLSLErrors) Error() string {
+	lines := make([]string, len(e))
+
+	for i, d := range e {
+		lines[i] = d.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type glslTokenKind int
+
+const (
+	glslEOF glslTokenKind = iota
+	glslIdentifier
+	glslIntConstant
+	glslFloatConstant
+	glslOperator
+)
+
+// GLSLToken is a single preprocessing token. Keywords are tokenized as
+// identifiers and only recognized as such by the parser, since the
+// preprocessor does not distinguish between them.
+type GLSLToken struct {
+	Kind     glslTokenKind
+	Text     string
+	Location GLSLLocation
+
+	// StartOfLine is set for the first token on a line
+	StartOfLine bool
+
+	// SpaceBefore is set when the token is preceded by whitespace
+	SpaceBefore bool
+}
+
+// glslOperators contains all operators, longest first
+var glslOperators = []string{
+	"<<=", ">>=",
+	"<<", ">>", "++", "--", "<=", ">=", "==", "!=", "&&", "||", "^^",
+	"*=", "/=", "+=", "-=", "%=", "&=", "^=", "|=",
+	"(", ")", "[", "]", "{", "}", ".", ",", ":", "=", ";", "!", "-", "~",
+	"+", "*", "/", "%", "<", ">", "|", "^", "&", "?", "#",
+}
+
+type glslLexer struct {
+	source string
+	pos    int
+	line   int
+	column int
+
+	tokens []GLSLToken
+	errors GLSLErrors
+}
+
+func isGLSLIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isGLSLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isGLSLHexDigit(c byte) bool {
+	return isGLSLDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// tokenizeGLSL splits a GLSL source into preprocessing tokens. Comments and
+// whitespace are dropped, but recorded in the StartOfLine and SpaceBefore
+// flags of the tokens following them.
+func tokenizeGLSL(source string) ([]GLSLToken, GLSLErrors) {
+	l := &glslLexer{
+		source: source,
+		line:   1,
+		column: 1,
+	}
+
+	l.run()
+	return l.tokens, l.errors
+}
+
+func (l *glslLexer) location() GLSLLocation {
+	return GLSLLocation{Line: l.line, Column: l.column}
+}
+
+func (l *glslLexer) errorf(loc GLSLLocation, format string, args ...interface{}) {
+	l.errors = append(l.errors, GLSLDiagnostic{
+		GLSLLocation: loc,
+		Message:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *glslLexer) peek(offset int) byte {
+	if l.pos+offset < len(l.source) {
+		return l.source[l.pos+offset]
+	}
+
+	return 0
+}
+
+func (l *glslLexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.source); i++ {
+		if l.source[l.pos] == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+
+		l.pos++
+	}
+}
+
+func (l *glslLexer) run() {
+	startOfLine := true
+	spaceBefore := false
+
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+
+		switch {
+		case c == '\n':
+			startOfLine = true
+			spaceBefore = true
+			l.advance(1)
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f':
+			spaceBefore = true
+			l.advance(1)
+			continue
+		case c == '/' && l.peek(1) == '/':
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.advance(1)
+			}
+
+			spaceBefore = true
+			continue
+		case c == '/' && l.peek(1) == '*':
+			loc := l.location()
+			end := strings.Index(l.source[l.pos+2:], "*/")
+
+			if end < 0 {
+				l.errorf(loc, "unterminated comment")
+				l.advance(len(l.source) - l.pos)
+			} else {
+				l.advance(end + 4)
+			}
+
+			spaceBefore = true
+			continue
+		}
+
+		tok := GLSLToken{
+			Location:    l.location(),
+			StartOfLine: startOfLine,
+			SpaceBefore: spaceBefore,
+		}
+
+		startOfLine = false
+		spaceBefore = false
+
+		start := l.pos
+
+		switch {
+		case isGLSLIdentStart(c):
+			for isGLSLIdentStart(l.peek(0)) || isGLSLDigit(l.peek(0)) {
+				l.advance(1)
+			}
+
+			tok.Kind = glslIdentifier
+		case isGLSLDigit(c) || (c == '.' && isGLSLDigit(l.peek(1))):
+			tok.Kind = l.number(tok.Location)
+		default:
+			op := ""
+
+			for _, o := range glslOperators {
+				if strings.HasPrefix(l.source[l.pos:], o) {
+					op = o
+					break
+				}
+			}
+
+			if len(op) == 0 {
+				r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+
+				l.errorf(tok.Location, "unexpected character %q", r)
+				l.advance(size)
+				continue
+			}
+
+			l.advance(len(op))
+			tok.Kind = glslOperator
+		}
+
+		tok.Text = l.source[start:l.pos]
+		l.tokens = append(l.tokens, tok)
+	}
+}
+
+func (l *glslLexer) digits(valid func(byte) bool) int {
+	n := 0
+
+	for valid(l.peek(0)) {
+		l.advance(1)
+		n++
+	}
+
+	return n
+}
+
+// number scans an integer or floating point constant
+func (l *glslLexer) number(loc GLSLLocation) glslTokenKind {
+	start := l.pos
+	kind := glslIntConstant
+
+	if l.peek(0) == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+		l.advance(2)
+
+		if l.digits(isGLSLHexDigit) == 0 {
+			l.errorf(loc, "invalid hexadecimal constant")
+		}
+	} else {
+		l.digits(isGLSLDigit)
+
+		if l.peek(0) == '.' {
+			kind = glslFloatConstant
+			l.advance(1)
+			l.digits(isGLSLDigit)
+		}
+
+		if c := l.peek(0); c == 'e' || c == 'E' {
+			kind = glslFloatConstant
+			l.advance(1)
+
+			if c := l.peek(0); c == '+' || c == '-' {
+				l.advance(1)
+			}
+
+			if l.digits(isGLSLDigit) == 0 {
+				l.errorf(loc, "missing exponent in floating point constant")
+			}
+		}
+
+		text := l.source[start:l.pos]
+
+		if kind == glslIntConstant && len(text) > 1 && text[0] == '0' && strings.IndexAny(text, "89") >= 0 {
+			l.errorf(loc, "invalid octal constant %s", text)
+		}
+	}
+
+	if isGLSLIdentStart(l.peek(0)) || isGLSLDigit(l.peek(0)) {
+		suffixStart := l.pos
+
+		for isGLSLIdentStart(l.peek(0)) || isGLSLDigit(l.peek(0)) {
+			l.advance(1)
+		}
+
+		l.errorf(loc, "invalid suffix %s on constant %s", l.source[suffixStart:l.pos], l.source[start:suffixStart])
+	}
+
+	return kind
+}
@@ -30,7 +30,8 @@
 package main
 
 import (
-	"crypto/sha1"
+	"crypto/sha256"
+	"math/big"
 )
 
 type Hasher struct {
@@ -38,33 +39,40 @@ type Hasher struct {
 
 var hasher Hasher
 
-func (h Hasher) shortHash(b []byte) string {
-	const ValidChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+const hashChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
-	ret := make([]byte, 10)
+// HashLength is the length of a base62 encoded SHA-256 digest. Hashes of any
+// other length were generated by the old, much weaker, 10 character hashing
+// scheme and are resolved through the aliases in the database.
+const HashLength = 43
 
-	for i, v := range b {
-		ret[i%10] += v
-	}
+func (h Hasher) encode(b []byte) string {
+	ret := make([]byte, HashLength)
 
-	bl := byte(len(ValidChars))
+	n := new(big.Int).SetBytes(b)
+	base := big.NewInt(int64(len(hashChars)))
+	rem := new(big.Int)
 
-	for i, v := range ret {
-		ret[i] = ValidChars[v%bl]
+	for i := len(ret) - 1; i >= 0; i-- {
+		n.DivMod(n, base, rem)
+		ret[i] = hashChars[rem.Int64()]
 	}
 
 	return string(ret)
 }
 
 func (h Hasher) Hash(data ...[]byte) string {
-	s := sha1.New()
+	s := sha256.New()
 
 	for _, d := range data {
 		s.Write(d)
 	}
 
-	ret := s.Sum(nil)
-	return h.shortHash(ret)
+	return h.encode(s.Sum(nil))
+}
+
+func (h Hasher) IsLegacyHash(s string) bool {
+	return len(s) != HashLength
 }
 
 func (h Hasher) ValidHash(s string) bool {
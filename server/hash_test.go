@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		data []string
+		hash string
+	}{
+		{nil, "19G3Nw6tOV7oOwWOlaoSSoqUkIQQ4ZXCETGoOZrv7sf"},
+		{[]string{"webgl"}, "tCbOHejzxYES0FGORILiTZ6AFmZkywbuxZl8OrfnxMI"},
+		{[]string{"web", "gl"}, "tCbOHejzxYES0FGORILiTZ6AFmZkywbuxZl8OrfnxMI"},
+	}
+
+	for _, test := range tests {
+		var data [][]byte
+
+		for _, d := range test.data {
+			data = append(data, []byte(d))
+		}
+
+		if hash := hasher.Hash(data...); hash != test.hash {
+			t.Errorf("%v: expected %s, got %s", test.data, test.hash, hash)
+		}
+	}
+}
+
+func TestHashKinds(t *testing.T) {
+	tests := []struct {
+		hash   string
+		legacy bool
+		valid  bool
+	}{
+		{"tCbOHejzxYES0FGORILiTZ6AFmZkywbuxZl8OrfnxMI", false, true},
+		{"aB3dE6gH9j", true, true},
+		{"", true, false},
+		{"abc/def", true, false},
+		{"../../etc", true, false},
+	}
+
+	for _, test := range tests {
+		if legacy := hasher.IsLegacyHash(test.hash); legacy != test.legacy {
+			t.Errorf("%q: expected legacy %v, got %v", test.hash, test.legacy, legacy)
+		}
+
+		if valid := hasher.ValidHash(test.hash); valid != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.hash, test.valid, valid)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// HTMLExportTemplateBody renders a document into a self-contained page. The
+// page embeds the standalone runtime of the playground (built from
+// js/standalone.js), which provides the same context to the document as the
+// playground itself, together with the assets and built-in models of the
+// document.
+const HTMLExportTemplateBody = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Document.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #000; font-family: sans-serif; }
+canvas { display: block; width: 100%; height: 100%; }
+#errors { display: none; position: absolute; top: 0; left: 0; right: 0; margin: 0; padding: 1em; background: rgba(120, 0, 0, 0.85); color: #fff; white-space: pre-wrap; }
+#attribution { position: absolute; bottom: 0; left: 0; right: 0; padding: 0.5em 1em; background: rgba(0, 0, 0, 0.6); color: #ccc; font-size: 12px; }
+#attribution a { color: #fff; }
+#attribution p { margin: 0; }
+</style>
+</head>
+<body>
+<canvas id="canvas" tabindex="0"></canvas>
+<pre id="errors"></pre>
+<div id="attribution">
+{{.Attribution.HTML}}
+</div>
+<script>
+// The built-in models used by the document are embedded in the page, nothing
+// is loaded from the server
+window.Settings = {
+    models: {{.Models}},
+    hooks: {}
+};
+</script>
+<script>
+{{.Runtime}}
+</script>
+<script>
+(function() {
+    'use strict';
+
+    var errors = document.getElementById('errors');
+
+    new Standalone(document.getElementById('canvas'), {{.Document}}, {{.Assets}}, function(msg) {
+        errors.style.display = 'block';
+        errors.textContent += msg + '\n';
+    });
+})();
+</script>
+</body>
+</html>
+`
+
+// standaloneRuntimePath is the path of the standalone runtime, relative to
+// the site data directory
+const standaloneRuntimePath = "assets/js/standalone.min.js"
+
+var scriptEndRegexp = regexp.MustCompile(`(?i)</(script)`)
+
+var htmlExportTemplate *template.Template
+
+type HTMLExportHandler struct {
+	RestishVoid
+}
+
+// HTMLExportInfo is rendered by the HTML export template. Assets maps the
+// names of the assets of the document to data URLs of their images, and Models
+// maps the names of the built-in models it uses to their contents.
+type HTMLExportInfo struct {
+	Document    *Document
+	Attribution *Attribution
+	Assets      map[string]string
+	Models      map[string]string
+	Runtime     template.JS
+}
+
+// standaloneRuntime reads the standalone runtime from the site data. Pages
+// cannot be exported without it, since documents depend on the models and
+// math modules of the playground.
+func standaloneRuntime() (template.JS, error) {
+	if len(siteRoot) == 0 {
+		return "", &APIError{
+			Code:    ErrorExportUnavailable,
+			Status:  http.StatusServiceUnavailable,
+			Message: "HTML export is not available without site data",
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(siteRoot, filepath.FromSlash(standaloneRuntimePath)))
+
+	if os.IsNotExist(err) {
+		return "", &APIError{
+			Code:    ErrorExportUnavailable,
+			Status:  http.StatusServiceUnavailable,
+			Message: fmt.Sprintf("HTML export is not available, the standalone runtime %s has not been built", standaloneRuntimePath),
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// The runtime is embedded in a script element, which would be ended by
+	// any </script in its strings
+	return template.JS(scriptEndRegexp.ReplaceAllString(string(data), `<\/$1`)), nil
+}
+
+// assetDataURLs returns the assets of a document as data URLs, keyed by name
+func assetDataURLs(doc *Document) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	for _, a := range doc.Assets {
+		data, err := AssetsStorage.Read(a.Hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret[a.Name] = "data:" + assetContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+
+	return ret, nil
+}
+
+// builtinModels returns the contents of the built-in models which are used by
+// a document, keyed by name. Models are only loaded by name from the
+// javascript of a document, so any quoted name of a model counts as a use.
+func builtinModels(doc *Document) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	if len(siteRoot) == 0 {
+		return ret, nil
+	}
+
+	root := filepath.Join(siteRoot, "assets", "models")
+	files, err := ioutil.ReadDir(root)
+
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		name := f.Name()
+
+		if f.IsDir() || (!strings.Contains(doc.Javascript, "'"+name+"'") && !strings.Contains(doc.Javascript, "\""+name+"\"")) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(root, name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret[name] = string(data)
+	}
+
+	return ret, nil
+}
+
+func (h HTMLExportHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	doc, err := LoadSharedDocument(req, id)
+
+	if err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	runtime, err := standaloneRuntime()
+
+	if err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	attribution, err := NewAttribution(doc, documentURL(req, id))
+
+	if err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	assets, err := assetDataURLs(doc)
+
+	if err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	models, err := builtinModels(doc)
+
+	if err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	info := HTMLExportInfo{
+		Document:    doc,
+		Attribution: attribution,
+		Assets:      assets,
+		Models:      models,
+		Runtime:     runtime,
+	}
+
+	if err := htmlExportTemplate.Execute(&buf, info); err != nil {
+		h.RespondError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Write(buf.Bytes())
+}
+
+func init() {
+	var err error
+
+	htmlExportTemplate, err = template.New("html").Parse(HTMLExportTemplateBody)
+
+	if err != nil {
+		panic(err)
+	}
+
+	router.Handle("/d/{id:[A-Za-z0-9]+}.html", MakeHandler(HTMLExportHandler{}, WrapCompress|WrapCORS))
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// defaultTestDocument returns the default document of the playground
+func defaultTestDocument(t *testing.T) *Document {
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join("../js/app", name))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(data)
+	}
+
+	doc := newTestDocument()
+	doc.Javascript = read("default.js")
+	doc.Programs[0].Vertex = read("default.glslv")
+	doc.Programs[0].Fragment = read("default.glslf")
+
+	return doc
+}
+
+func TestHTMLExport(t *testing.T) {
+	setupTest(t)
+
+	prevSiteRoot := siteRoot
+	siteRoot = t.TempDir()
+
+	t.Cleanup(func() {
+		siteRoot = prevSiteRoot
+	})
+
+	models := filepath.Join(siteRoot, "assets", "models")
+
+	if err := os.MkdirAll(models, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"used.obj":   "o used\nv 0 0 0\n",
+		"unused.obj": "o unused\nv 1 1 1\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(models, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	doc := defaultTestDocument(t)
+	doc.Javascript += "\nvar model = c.models.Model.load('used.obj');\n"
+
+	hash := storeTestDocument(t, doc)
+
+	w := serveTest(t, "GET", "/d/"+hash+".html", nil, nil)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d without runtime, but got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	var resp errorResponse
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code != ErrorExportUnavailable {
+		t.Errorf("expected %s, but got %s", ErrorExportUnavailable, w.Body.String())
+	}
+
+	runtime := filepath.Join(siteRoot, filepath.FromSlash(standaloneRuntimePath))
+
+	if err := os.MkdirAll(filepath.Dir(runtime), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(runtime, []byte("function Standalone() { return '</SCRIPT>'; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serveTest(t, "GET", "/d/"+hash+".html", nil, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	page := w.Body.String()
+
+	for _, s := range []string{
+		"function Standalone() { return '<\\/SCRIPT>'; }",
+		"new Standalone(",
+		"c.models.View.perspective",
+		`"used.obj":"o used\nv 0 0 0\n"`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("expected page to contain %q", s)
+		}
+	}
+
+	// The runtime used to stub out the modules of the playground
+	for _, s := range []string{"unavailable(", "not available in standalone pages"} {
+		if strings.Contains(page, s) {
+			t.Errorf("expected page not to contain %q", s)
+		}
+	}
+
+	// Nothing but the document link refers to the server
+	for _, s := range []string{"unused.obj", "assets/"} {
+		if strings.Contains(page, s) {
+			t.Errorf("expected page not to contain %q", s)
+		}
+	}
+}
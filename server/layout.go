@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The shortest (legacy) hashes are 10 characters, and at least one character
+// needs to remain for the file name
+const maxShardChars = 9
+
+// ShardLayout describes how blobs are spread over directories, as the number
+// of hash characters used for each directory level. The layout 2/2 for
+// example stores the blob with hash abcdefg at ab/cd/efg.
+type ShardLayout []int
+
+func ParseShardLayout(s string) (ShardLayout, error) {
+	var ret ShardLayout
+
+	total := 0
+
+	for _, part := range strings.Split(s, "/") {
+		n, err := strconv.ParseInt(part, 10, 32)
+
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid shard layout %s", s)
+		}
+
+		total += int(n)
+		ret = append(ret, int(n))
+	}
+
+	if total > maxShardChars {
+		return nil, fmt.Errorf("Invalid shard layout %s, at most %d characters can be used for directories", s, maxShardChars)
+	}
+
+	return ret, nil
+}
+
+func (l ShardLayout) Path(hash string) string {
+	parts := make([]string, 0, len(l)+1)
+
+	for _, n := range l {
+		// Hashes which are too short to be sharded are never stored, but
+		// should not prevent looking them up
+		if len(hash) <= n {
+			break
+		}
+
+		parts = append(parts, hash[:n])
+		hash = hash[n:]
+	}
+
+	return path.Join(append(parts, hash)...)
+}
+
+type ReshardCommand struct {
+}
+
+// reshard moves every blob in s, including encoded variants, which is not
+// stored according to the current layout. Blobs are created at their new
+// location before they are removed from the old one, so that a running
+// server configured with the old layout as its fallback layout keeps finding
+// them.
+func (c *ReshardCommand) reshard(s *Storage) (int, error) {
+	var names []string
+
+	err := s.Backend.List(func(info BlobInfo) error {
+		names = append(names, info.Name)
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+
+	for _, name := range names {
+		base, ext := s.splitVariant(name)
+		target := s.HashPath(s.PathHash(base)) + ext
+
+		if target == name {
+			continue
+		}
+
+		data, err := s.Backend.Read(name)
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return moved, err
+		}
+
+		if err := s.Backend.Create(target, data); err != nil && !os.IsExist(err) {
+			return moved, err
+		}
+
+		if err := s.removeBlob(name); err != nil && !os.IsNotExist(err) {
+			return moved, err
+		}
+
+		moved++
+	}
+
+	return moved, nil
+}
+
+func (c *ReshardCommand) Execute(args []string) error {
+	if err := setup(); err != nil {
+		return err
+	}
+
+	for _, s := range Storages() {
+		n, err := c.reshard(s)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Moved %d %s\n", n, s.Directory)
+	}
+
+	return nil
+}
+
+func init() {
+	parser.AddCommand("reshard",
+		"Move documents and screenshots to the current shard layout",
+		"Moves all documents and screenshots which are stored according to a different shard layout to the layout given by --shard-layout.",
+		&ReshardCommand{})
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseShardLayout(t *testing.T) {
+	tests := []struct {
+		layout string
+		valid  bool
+	}{
+		{"2", true},
+		{"2/2", true},
+		{"3/3/3", true},
+		{"0", false},
+		{"-1", false},
+		{"2/", false},
+		{"", false},
+		{"a", false},
+		{"5/5", false},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseShardLayout(test.layout); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.layout, test.valid, err)
+		}
+	}
+}
+
+func TestShardLayoutPath(t *testing.T) {
+	tests := []struct {
+		layout ShardLayout
+		hash   string
+		path   string
+	}{
+		{ShardLayout{2}, "abcdefg", "ab/cdefg"},
+		{ShardLayout{2, 2}, "abcdefg", "ab/cd/efg"},
+		{ShardLayout{1, 3}, "abcdefg", "a/bcd/efg"},
+		{ShardLayout{2, 2}, "abcd", "ab/cd"},
+		{ShardLayout{2, 2}, "abc", "ab/c"},
+		{ShardLayout{2}, "ab", "ab"},
+	}
+
+	for _, test := range tests {
+		if p := test.layout.Path(test.hash); p != test.path {
+			t.Errorf("%v %s: expected %s, got %s", test.layout, test.hash, test.path, p)
+		}
+	}
+}
+
+func TestReshard(t *testing.T) {
+	setupTest(t)
+
+	old := DocumentStorage.Layout
+	hash := storeTestDocument(t, newTestDocument())
+
+	DocumentStorage.Layout = ShardLayout{2, 2}
+	DocumentStorage.FallbackLayout = old
+
+	defer func() {
+		DocumentStorage.Layout = old
+		DocumentStorage.FallbackLayout = nil
+	}()
+
+	// Blobs which have not been moved are found in the fallback layout
+	if _, err := DocumentStorage.Read(hash); err != nil {
+		t.Fatalf("expected blob to be found in the fallback layout: %v", err)
+	}
+
+	var c ReshardCommand
+
+	// The blob and its gzip variant are moved
+	if n, err := c.reshard(DocumentStorage); err != nil || n != 2 {
+		t.Fatalf("expected 2 moved blobs, got %d, %v", n, err)
+	}
+
+	for _, name := range []string{DocumentStorage.HashPath(hash), DocumentStorage.HashPath(hash) + GzipEncoding.Extension} {
+		if _, err := DocumentStorage.Backend.Read(name); err != nil {
+			t.Errorf("expected %s to exist: %v", name, err)
+		}
+	}
+
+	DocumentStorage.FallbackLayout = nil
+
+	if _, err := DocumentStorage.Read(hash); err != nil {
+		t.Errorf("expected blob to be found in the new layout: %v", err)
+	}
+
+	if n, err := c.reshard(DocumentStorage); err != nil || n != 0 {
+		t.Errorf("expected nothing left to move, got %d, %v", n, err)
+	}
+}
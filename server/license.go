@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// License describes the terms under which an author shares a document.
+// Licenses are identified by the short names of the Creative Commons
+// licenses used by the playground, or by their SPDX identifier for other
+// licenses.
+type License struct {
+	ID        string `json:"id"`
+	SPDX      string `json:"spdx"`
+	Name      string `json:"name"`
+	ShortName string `json:"shortName"`
+	URL       string `json:"url"`
+
+	// PublicDomain is set for licenses which waive all rights
+	PublicDomain bool `json:"publicDomain"`
+
+	// Attribution requires adaptations to credit the author
+	Attribution bool `json:"attribution"`
+
+	// NonCommercial requires adaptations to be licensed for non-commercial
+	// use only
+	NonCommercial bool `json:"nonCommercial"`
+
+	// ShareAlike requires adaptations to be licensed under the same license
+	ShareAlike bool `json:"shareAlike"`
+}
+
+var Licenses = []*License{
+	{
+		ID:           "CC 0",
+		SPDX:         "CC0-1.0",
+		Name:         "Creative Commons Zero 1.0 Universal",
+		ShortName:    "CC0 1.0",
+		URL:          "https://creativecommons.org/publicdomain/zero/1.0/",
+		PublicDomain: true,
+	},
+	{
+		ID:          "CC BY",
+		SPDX:        "CC-BY-4.0",
+		Name:        "Creative Commons Attribution 4.0 International",
+		ShortName:   "CC BY 4.0",
+		URL:         "https://creativecommons.org/licenses/by/4.0/",
+		Attribution: true,
+	},
+	{
+		ID:            "CC BY-NC",
+		SPDX:          "CC-BY-NC-4.0",
+		Name:          "Creative Commons Attribution-NonCommercial 4.0 International",
+		ShortName:     "CC BY-NC 4.0",
+		URL:           "https://creativecommons.org/licenses/by-nc/4.0/",
+		Attribution:   true,
+		NonCommercial: true,
+	},
+	{
+		ID:          "CC BY-SA",
+		SPDX:        "CC-BY-SA-4.0",
+		Name:        "Creative Commons Attribution-ShareAlike 4.0 International",
+		ShortName:   "CC BY-SA 4.0",
+		URL:         "https://creativecommons.org/licenses/by-sa/4.0/",
+		Attribution: true,
+		ShareAlike:  true,
+	},
+	{
+		ID:            "CC BY-NC-SA",
+		SPDX:          "CC-BY-NC-SA-4.0",
+		Name:          "Creative Commons Attribution-NonCommercial-ShareAlike 4.0 International",
+		ShortName:     "CC BY-NC-SA 4.0",
+		URL:           "https://creativecommons.org/licenses/by-nc-sa/4.0/",
+		Attribution:   true,
+		NonCommercial: true,
+		ShareAlike:    true,
+	},
+	{
+		ID:          "MIT",
+		SPDX:        "MIT",
+		Name:        "MIT License",
+		ShortName:   "MIT License",
+		URL:         "https://opensource.org/licenses/MIT",
+		Attribution: true,
+	},
+}
+
+// LookupLicense returns the license with the given identifier, or nil if
+// there is no such license
+func LookupLicense(id string) *License {
+	for _, l := range Licenses {
+		if l.ID == id {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// Permits returns the term of l which is broken when an adaptation of a work
+// licensed under l is licensed under other, or an empty string if l permits
+// it
+func (l *License) Permits(other *License) string {
+	switch {
+	case l.PublicDomain:
+		return ""
+	case l.ShareAlike && other != l:
+		return "ShareAlike"
+	case l.NonCommercial && !other.NonCommercial:
+		return "NonCommercial"
+	case l.Attribution && !other.Attribution:
+		return "Attribution"
+	}
+
+	return ""
+}
+
+var licenseTermDescriptions = map[string]string{
+	"ShareAlike":    "requires adaptations to be licensed under the same license",
+	"NonCommercial": "requires adaptations to be licensed for non-commercial use only",
+	"Attribution":   "requires adaptations to keep crediting the author",
+}
+
+// LicenseError is the error of an author chain in which an author licenses
+// their adaptation in a way which breaks the terms of an earlier author
+type LicenseError struct {
+	Author  Author `json:"author"`
+	Earlier Author `json:"earlier"`
+	Term    string `json:"term"`
+}
+
+func authorDescription(a Author) string {
+	if len(a.Name) == 0 {
+		return fmt.Sprintf("an anonymous author (%d)", a.Year)
+	}
+
+	return fmt.Sprintf("%s (%d)", a.Name, a.Year)
+}
+
+func (e *LicenseError) Error() string {
+	return fmt.Sprintf("Cannot change license from %s to %s: the %s license of %s %s",
+		e.Earlier.License,
+		e.Author.License,
+		e.Earlier.License,
+		authorDescription(e.Earlier),
+		licenseTermDescriptions[e.Term])
+}
+
+// CheckLicense checks that an author who is added to a chain licenses their
+// adaptation according to the terms of the licenses of all earlier authors.
+// Transitions between earlier authors are not checked, so that chains which
+// were accepted before these terms were enforced remain valid.
+func CheckLicense(earlier []Author, a Author) error {
+	license := LookupLicense(a.License)
+
+	if license == nil {
+		return InvalidError(ErrorInvalidLicense, "license", "Invalid license %s", a.License)
+	}
+
+	for i, e := range earlier {
+		el := LookupLicense(e.License)
+
+		if el == nil {
+			return InvalidError(ErrorInvalidLicense, fmt.Sprintf("document.authors[%d].license", i), "Invalid license %s", e.License)
+		}
+
+		if term := el.Permits(license); len(term) != 0 {
+			return &LicenseError{
+				Author:  a,
+				Earlier: e,
+				Term:    term,
+			}
+		}
+	}
+
+	return nil
+}
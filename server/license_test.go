@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestLicensePermits(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		term string
+	}{
+		{"CC 0", "CC 0", ""},
+		{"CC 0", "CC BY-NC-SA", ""},
+		{"CC BY", "CC BY", ""},
+		{"CC BY", "CC BY-SA", ""},
+		{"CC BY", "MIT", ""},
+		{"CC BY", "CC 0", "Attribution"},
+		{"MIT", "CC 0", "Attribution"},
+		{"CC BY-NC", "CC BY-NC-SA", ""},
+		{"CC BY-NC", "CC BY", "NonCommercial"},
+		{"CC BY-SA", "CC BY-SA", ""},
+		{"CC BY-SA", "CC BY", "ShareAlike"},
+		{"CC BY-SA", "CC BY-NC-SA", "ShareAlike"},
+		{"CC BY-NC-SA", "CC BY-NC", "ShareAlike"},
+	}
+
+	for _, test := range tests {
+		if term := LookupLicense(test.from).Permits(LookupLicense(test.to)); term != test.term {
+			t.Errorf("%s -> %s: expected %q, but got %q", test.from, test.to, test.term, term)
+		}
+	}
+}
+
+func TestCheckLicense(t *testing.T) {
+	author := func(license string, year int) Author {
+		return Author{Name: license, License: license, Year: year}
+	}
+
+	tests := []struct {
+		earlier []Author
+		license string
+
+		// broken is the license of the earlier author whose terms are
+		// broken, or invalid for an invalid license
+		broken string
+		term   string
+	}{
+		{nil, "CC 0", "", ""},
+		{nil, "GPL", "invalid", ""},
+		{[]Author{author("CC BY", 2014)}, "CC BY-NC", "", ""},
+		{[]Author{author("CC BY-NC", 2014), author("CC BY-NC-SA", 2015)}, "CC BY-NC", "CC BY-NC-SA", "ShareAlike"},
+		{[]Author{author("CC BY-SA", 2014), author("CC BY-SA", 2015)}, "CC BY-NC-SA", "CC BY-SA", "ShareAlike"},
+		{[]Author{author("CC BY-NC", 2014), author("CC BY", 2015)}, "CC BY", "CC BY-NC", "NonCommercial"},
+		{[]Author{author("CC 0", 2014), author("MIT", 2015)}, "CC 0", "MIT", "Attribution"},
+		// Earlier transitions which break the terms of an author are not
+		// checked, only the transition to the new author
+		{[]Author{author("CC BY-NC", 2014), author("CC BY", 2015)}, "CC BY-NC", "", ""},
+		{[]Author{author("CC BY", 2014), author("CC 0", 2015)}, "CC BY", "", ""},
+		{[]Author{author("Unknown", 2014)}, "CC BY", "invalid", ""},
+	}
+
+	for i, test := range tests {
+		err := CheckLicense(test.earlier, author(test.license, 2016))
+
+		switch e := err.(type) {
+		case nil:
+			if test.broken != "" {
+				t.Errorf("%d: expected %s of %s to be broken, but got no error", i, test.term, test.broken)
+			}
+		case *LicenseError:
+			if e.Earlier.License != test.broken || e.Term != test.term || e.Author.License != test.license {
+				t.Errorf("%d: expected %s of %s to be broken, but got %+v", i, test.term, test.broken, e)
+			}
+		case *APIError:
+			if test.broken != "invalid" || e.Code != ErrorInvalidLicense {
+				t.Errorf("%d: unexpected error %v", i, e)
+			}
+		default:
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestPrepareLicenses(t *testing.T) {
+	nc := Author{Name: "A", License: "CC BY-NC", Year: 2014}
+	by := Author{Name: "B", License: "CC BY", Year: 2015}
+
+	tests := []struct {
+		author  Author
+		authors int
+		broken  bool
+	}{
+		// The last author can prepare a chain accepted before licenses were
+		// checked this strictly
+		{by, 2, false},
+		{Author{Name: "C", License: "CC BY-NC", Year: 2016}, 3, false},
+		{Author{Name: "C", License: "CC BY", Year: 2016}, 2, true},
+	}
+
+	for i, test := range tests {
+		doc := newTestDocument()
+		doc.Authors = []Author{nc, by}
+
+		err := doc.Prepare(test.author, nil)
+
+		if _, ok := err.(*LicenseError); ok != test.broken || (err != nil && !ok) {
+			t.Errorf("%d: expected license error: %v, but got %v", i, test.broken, err)
+		}
+
+		if len(doc.Authors) != test.authors {
+			t.Errorf("%d: expected %d authors, but got %d", i, test.authors, len(doc.Authors))
+		}
+	}
+}
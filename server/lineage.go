@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// maxLineageDepth limits the number of ancestors returned for a document
+const maxLineageDepth = 100
+
+// maxLineageNodes limits the number of descendants returned for a document
+const maxLineageNodes = 1000
+
+type LineageHandler struct {
+	RestishVoid
+}
+
+// LineageNode is a document in a fork tree. Documents which are referenced
+// but have since been deleted are marked as missing, and ancestors which can
+// only be read with a key are marked as private.
+type LineageNode struct {
+	Hash     string         `json:"hash"`
+	Title    string         `json:"title,omitempty"`
+	Authors  []Author       `json:"authors,omitempty"`
+	Missing  bool           `json:"missing,omitempty"`
+	Private  bool           `json:"private,omitempty"`
+	Children []*LineageNode `json:"children,omitempty"`
+}
+
+// Lineage contains the ancestors of a document, nearest first, and the tree
+// of its known descendants. Truncated is set when not all descendants are
+// included in the tree.
+type Lineage struct {
+	Ancestors []*LineageNode `json:"ancestors"`
+	Tree      *LineageNode   `json:"tree"`
+	Truncated bool           `json:"truncated,omitempty"`
+}
+
+func loadLineageNode(hash string) (*LineageNode, *Document, error) {
+	node := &LineageNode{
+		Hash: hash,
+	}
+
+	data, found, err := DocumentStorage.read(hash, "")
+
+	if os.IsNotExist(err) {
+		node.Missing = true
+		return node, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc Document
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	node.Hash = found
+	node.Title = doc.Title
+	node.Authors = doc.Authors
+
+	return node, &doc, nil
+}
+
+// LoadLineage returns the ancestors and descendants of the document with the
+// given hash, which is read with the given keys. Ancestors are found through
+// the parents recorded in the documents, while descendants are found through
+// the forks recorded when documents are stored. Only listed descendants are
+// included.
+func LoadLineage(hash string, keys []string) (*Lineage, error) {
+	if _, err := documentAccess(keys, hash); err != nil {
+		return nil, err
+	}
+
+	tree, doc, err := loadLineageNode(hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if doc == nil {
+		return nil, os.ErrNotExist
+	}
+
+	ret := &Lineage{
+		Ancestors: []*LineageNode{},
+		Tree:      tree,
+	}
+
+	for parent := doc.Parent; len(parent) != 0 && len(ret.Ancestors) < maxLineageDepth; {
+		// The parents of private ancestors are not revealed either
+		if _, err := documentAccess(nil, parent); err != nil {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorNotFound {
+				return nil, err
+			}
+
+			ret.Ancestors = append(ret.Ancestors, &LineageNode{Hash: parent, Private: true})
+			break
+		}
+
+		node, pdoc, err := loadLineageNode(parent)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Ancestors = append(ret.Ancestors, node)
+
+		if pdoc == nil {
+			break
+		}
+
+		parent = pdoc.Parent
+	}
+
+	queue := []*LineageNode{tree}
+	n := 0
+
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		forks, err := db.Forks(node.Hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fork := range forks {
+			if listed, err := documentListed(fork); err != nil {
+				return nil, err
+			} else if !listed {
+				continue
+			}
+
+			if n == maxLineageNodes {
+				ret.Truncated = true
+				return ret, nil
+			}
+
+			child, _, err := loadLineageNode(fork)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.Children = append(node.Children, child)
+			queue = append(queue, child)
+			n++
+		}
+	}
+
+	return ret, nil
+}
+
+func (l LineageHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	lineage, err := LoadLineage(mux.Vars(req)["id"], requestKeys(req))
+
+	if err != nil {
+		l.RespondError(writer, err)
+		return
+	}
+
+	l.RespondJSON(writer, lineage)
+}
+
+func init() {
+	router.Handle("/d/{id:[A-Za-z0-9]+}/tree.json", MakeHandler(LineageHandler{}, WrapCompress|WrapCORS))
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrepareParentAccess(t *testing.T) {
+	setupTest(t)
+
+	public := storeTestDocument(t, newTestDocument())
+	private, share := shareTestDocument(t, "Private", "", VisibilityPrivate)
+
+	tests := []struct {
+		parent string
+		keys   []string
+		code   string
+	}{
+		{public, nil, ""},
+		{private, []string{share.Key}, ""},
+		{private, nil, ErrorParentNotFound},
+		{private, []string{"wrong"}, ErrorParentNotFound},
+		{"0000", nil, ErrorParentNotFound},
+		{"not a hash", nil, ErrorInvalidField},
+	}
+
+	for _, test := range tests {
+		doc := newTestDocument()
+		doc.Title = "Fork"
+		doc.Parent = test.parent
+
+		err := doc.Prepare(doc.Authors[0], test.keys)
+
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s %v: unexpected error: %v", test.parent, test.keys, err)
+			}
+
+			continue
+		}
+
+		if aerr, ok := err.(*APIError); !ok || aerr.Code != test.code {
+			t.Errorf("%s %v: expected %s, but got %v", test.parent, test.keys, test.code, err)
+		}
+	}
+}
+
+func TestLoadLineage(t *testing.T) {
+	setupTest(t)
+
+	root := storeTestDocument(t, newTestDocument())
+	child, _ := shareTestDocument(t, "Child", root, VisibilityPublic)
+	unlisted, _ := shareTestDocument(t, "Unlisted", child, VisibilityUnlisted)
+	private, share := shareTestDocument(t, "Private", root, VisibilityPrivate)
+	fork, _ := shareTestDocument(t, "Fork", private, VisibilityPublic, share.Key)
+
+	tests := []struct {
+		hash      string
+		keys      []string
+		ancestors []string
+		children  []string
+		notFound  bool
+	}{
+		{hash: root, children: []string{child}},
+		{hash: child, ancestors: []string{root}},
+		{hash: unlisted, ancestors: []string{child, root}},
+		{hash: private, notFound: true},
+		{hash: private, keys: []string{share.Key}, ancestors: []string{root}, children: []string{fork}},
+		// the private parent is not revealed, nor are its ancestors
+		{hash: fork, ancestors: []string{private + " private"}},
+	}
+
+	for _, test := range tests {
+		lineage, err := LoadLineage(test.hash, test.keys)
+
+		if test.notFound {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorNotFound {
+				t.Errorf("%s: expected not found, but got %v", test.hash, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.hash, err)
+			continue
+		}
+
+		var ancestors, children []string
+
+		for _, a := range lineage.Ancestors {
+			if a.Private {
+				ancestors = append(ancestors, a.Hash+" private")
+			} else {
+				ancestors = append(ancestors, a.Hash)
+			}
+		}
+
+		for _, c := range lineage.Tree.Children {
+			children = append(children, c.Hash)
+		}
+
+		if strings.Join(ancestors, " ") != strings.Join(test.ancestors, " ") {
+			t.Errorf("%s: expected ancestors %v, but got %v", test.hash, test.ancestors, ancestors)
+		}
+
+		if strings.Join(children, " ") != strings.Join(test.children, " ") {
+			t.Errorf("%s: expected children %v, but got %v", test.hash, test.children, children)
+		}
+	}
+}
+
+func TestLineageHandler(t *testing.T) {
+	setupTest(t)
+
+	private, share := shareTestDocument(t, "Private", "", VisibilityPrivate)
+
+	if w := serveTest(t, "GET", "/d/"+private+"/tree.json", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d without key, but got %d", http.StatusNotFound, w.Code)
+	}
+
+	w := serveTest(t, "GET", "/d/"+private+"/tree.json?key="+share.Key, nil, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var lineage Lineage
+
+	if err := json.Unmarshal(w.Body.Bytes(), &lineage); err != nil {
+		t.Fatal(err)
+	}
+
+	if lineage.Tree == nil || lineage.Tree.Hash != private || lineage.Tree.Title != "Private" {
+		t.Errorf("unexpected lineage %+v", lineage)
+	}
+}
@@ -51,13 +51,13 @@ func (d *ModelHandler) Get(writer http.ResponseWriter, req *http.Request) {
 	u, err := url.Parse(vars["url"])
 
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		d.RespondError(writer, InvalidError(ErrorInvalidField, "url", "%s", err.Error()))
 		return
 	}
 
 	if !u.IsAbs() {
 		if options.SiteData == "-" {
-			http.Error(writer, "404 not found", http.StatusNotFound)
+			d.RespondError(writer, NotFoundError())
 			return
 		}
 
@@ -65,7 +65,7 @@ func (d *ModelHandler) Get(writer http.ResponseWriter, req *http.Request) {
 		filename := path.Clean(path.Join(root, u.Path))
 
 		if !strings.HasPrefix(filename, root+"/") {
-			http.Error(writer, "404 not found", http.StatusNotFound)
+			d.RespondError(writer, NotFoundError())
 			return
 		}
 
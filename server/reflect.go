@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ReflectHandler struct {
+	RestishVoid
+}
+
+// ReflectVariable is a variable in the interface of a program. Shaders lists
+// the shaders declaring it.
+type ReflectVariable struct {
+	Name      string             `json:"name"`
+	Type      string             `json:"type"`
+	Precision string             `json:"precision,omitempty"`
+	ArraySize string             `json:"arraySize,omitempty"`
+	Invariant bool               `json:"invariant,omitempty"`
+	Fields    []*ReflectVariable `json:"fields,omitempty"`
+	Shaders   []string           `json:"shaders,omitempty"`
+
+	signature string
+}
+
+// LinkError is an error which prevents the shaders of a program from being
+// linked together
+type LinkError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+type ProgramReflection struct {
+	Name       string             `json:"name"`
+	IsDefault  bool               `json:"isDefault"`
+	Attributes []*ReflectVariable `json:"attributes"`
+	Uniforms   []*ReflectVariable `json:"uniforms"`
+	Varyings   []*ReflectVariable `json:"varyings"`
+
+	CompileErrors []*ShaderError `json:"compileErrors,omitempty"`
+	LinkErrors    []LinkError    `json:"linkErrors,omitempty"`
+}
+
+type DocumentReflection struct {
+	Hash     string               `json:"hash"`
+	Programs []*ProgramReflection `json:"programs"`
+}
+
+func reflectVariable(v *GLSLVariable) *ReflectVariable {
+	ret := &ReflectVariable{
+		Name:      v.Name,
+		Type:      v.Type.Name,
+		Precision: v.Precision,
+		ArraySize: v.ArraySize,
+		Invariant: v.Invariant,
+	}
+
+	var fields []string
+
+	if s := v.Type.Struct; s != nil {
+		if len(ret.Type) == 0 {
+			ret.Type = "struct"
+		}
+
+		for _, f := range s.Fields {
+			field := reflectVariable(f)
+
+			ret.Fields = append(ret.Fields, field)
+			fields = append(fields, field.signature+" "+field.Name)
+		}
+	}
+
+	// The signature identifies the type of the variable, for comparing
+	// declarations in different shaders
+	ret.signature = ret.Type
+
+	if len(fields) != 0 {
+		ret.signature += " { " + strings.Join(fields, "; ") + " }"
+	}
+
+	if v.IsArray {
+		ret.signature += "[" + v.ArraySize + "]"
+	}
+
+	return ret
+}
+
+// reflectVariables adds the variables with the given qualifier declared in a
+// shader to vars. Variables which were already declared in another shader
+// are checked to match the previous declaration.
+func (r *ProgramReflection) reflectVariables(vars []*ReflectVariable, unit *GLSLUnit, qualifier string) []*ReflectVariable {
+	shader := unit.Type.String()
+
+	for _, v := range unit.Variables {
+		if v.Qualifier != qualifier {
+			continue
+		}
+
+		rv := reflectVariable(v)
+
+		var prev *ReflectVariable
+
+		for _, other := range vars {
+			if other.Name == rv.Name {
+				prev = other
+				break
+			}
+		}
+
+		if prev == nil {
+			rv.Shaders = []string{shader}
+			vars = append(vars, rv)
+			continue
+		}
+
+		if prev.Shaders[len(prev.Shaders)-1] != shader {
+			prev.Shaders = append(prev.Shaders, shader)
+		}
+
+		switch {
+		case prev.signature != rv.signature:
+			r.linkErrorf(rv.Name, "%s %s is declared as %s in the %s shader, but as %s in the %s shader", qualifier, rv.Name, prev.signature, prev.Shaders[0], rv.signature, shader)
+		case qualifier == "uniform" && prev.Precision != rv.Precision:
+			r.linkErrorf(rv.Name, "uniform %s has precision %s in the %s shader, but %s in the %s shader", rv.Name, prev.Precision, prev.Shaders[0], rv.Precision, shader)
+		case qualifier == "varying" && prev.Invariant != rv.Invariant:
+			r.linkErrorf(rv.Name, "varying %s must be invariant in both shaders, or in neither", rv.Name)
+		}
+	}
+
+	return vars
+}
+
+func (r *ProgramReflection) linkErrorf(name string, format string, args ...interface{}) {
+	r.LinkErrors = append(r.LinkErrors, LinkError{
+		Name:    name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// markInvariants marks the varyings which are redeclared as invariant
+func markInvariants(unit *GLSLUnit) {
+	for _, name := range unit.Invariants {
+		for _, v := range unit.Variables {
+			if v.Name == name && v.Qualifier == "varying" {
+				v.Invariant = true
+			}
+		}
+	}
+}
+
+// ReflectProgram returns the attributes, uniforms and varyings declared by
+// the shaders of a program, and checks that the shaders can be linked.
+// Shaders which do not compile are reported in the reflection, other errors
+// are returned.
+func ReflectProgram(p *Program) (*ProgramReflection, error) {
+	r := &ProgramReflection{
+		Name:       p.Name,
+		IsDefault:  p.IsDefault,
+		Attributes: []*ReflectVariable{},
+		Uniforms:   []*ReflectVariable{},
+		Varyings:   []*ReflectVariable{},
+	}
+
+	compiled := 0
+
+	sources := []struct {
+		source string
+		typ    GLSLShaderType
+	}{
+		{p.Vertex, GLSLVertexShader},
+		{p.Fragment, GLSLFragmentShader},
+	}
+
+	for _, s := range sources {
+		unit, err := ParseGLSL(s.source, s.typ)
+
+		if err != nil {
+			diagnostics, ok := err.(GLSLErrors)
+
+			if !ok {
+				return nil, err
+			}
+
+			r.CompileErrors = append(r.CompileErrors, &ShaderError{
+				Program:     p.Name,
+				Shader:      s.typ.String(),
+				Diagnostics: diagnostics,
+			})
+
+			continue
+		}
+
+		markInvariants(unit)
+		compiled++
+
+		r.Attributes = r.reflectVariables(r.Attributes, unit, "attribute")
+		r.Uniforms = r.reflectVariables(r.Uniforms, unit, "uniform")
+		r.Varyings = r.reflectVariables(r.Varyings, unit, "varying")
+	}
+
+	// Varyings read by the fragment shader have to be written by the vertex
+	// shader. Since only declarations are known, any varying declared in
+	// the fragment shader is required to be declared in the vertex shader.
+	if compiled == len(sources) {
+		for _, v := range r.Varyings {
+			if len(v.Shaders) == 1 && v.Shaders[0] == "fragment" {
+				r.linkErrorf(v.Name, "varying %s is declared in the fragment shader, but not in the vertex shader", v.Name)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func ReflectDocument(hash string, doc *Document) (*DocumentReflection, error) {
+	ret := &DocumentReflection{
+		Hash:     hash,
+		Programs: make([]*ProgramReflection, len(doc.Programs)),
+	}
+
+	for i := range doc.Programs {
+		r, err := ReflectProgram(&doc.Programs[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Programs[i] = r
+	}
+
+	return ret, nil
+}
+
+func (r ReflectHandler) Get(writer http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	doc, err := LoadSharedDocument(req, id)
+
+	if err != nil {
+		r.RespondError(writer, err)
+		return
+	}
+
+	reflection, err := ReflectDocument(id, doc)
+
+	if err != nil {
+		r.RespondError(writer, err)
+		return
+	}
+
+	r.RespondJSON(writer, reflection)
+}
+
+func init() {
+	router.Handle("/d/{id:[A-Za-z0-9]+}/reflect.json", MakeHandler(ReflectHandler{}, WrapCompress|WrapCORS))
+}
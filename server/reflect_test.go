@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReflectProgram(t *testing.T) {
+	const fragment = "precision mediump float;\nvoid main() {\n\tgl_FragColor = vec4(1.0);\n}\n"
+
+	tests := []struct {
+		name       string
+		vertex     string
+		fragment   string
+		attributes []string
+		uniforms   []string
+		varyings   []string
+		link       []string
+		compile    []string
+	}{
+		{
+			name:       "interface",
+			vertex:     "attribute vec3 a_Position;\nuniform highp mat4 u_Model[2];\nvarying vec2 v_Coord;\nvoid main() {}\n",
+			fragment:   "precision mediump float;\nuniform highp mat4 u_Model[2];\nvarying vec2 v_Coord;\nvoid main() {}\n",
+			attributes: []string{"vec3 a_Position vertex"},
+			uniforms:   []string{"mat4 u_Model[2] vertex,fragment"},
+			varyings:   []string{"vec2 v_Coord vertex,fragment"},
+		},
+		{
+			name:     "struct",
+			vertex:   "struct Light { vec3 position; float intensity; };\nuniform Light u_Light;\nvoid main() {}\n",
+			fragment: fragment,
+			uniforms: []string{"Light u_Light vertex"},
+		},
+		{
+			name:     "type mismatch",
+			vertex:   "varying vec2 v_Coord;\nvoid main() {}\n",
+			fragment: "precision mediump float;\nvarying vec3 v_Coord;\nvoid main() {}\n",
+			varyings: []string{"vec2 v_Coord vertex,fragment"},
+			link:     []string{"v_Coord"},
+		},
+		{
+			name:     "missing varying",
+			vertex:   "void main() {}\n",
+			fragment: "precision mediump float;\nvarying vec3 v_Normal;\nvoid main() {}\n",
+			varyings: []string{"vec3 v_Normal fragment"},
+			link:     []string{"v_Normal"},
+		},
+		{
+			name:     "uniform precision",
+			vertex:   "uniform highp float u_Time;\nvoid main() {}\n",
+			fragment: "precision mediump float;\nuniform float u_Time;\nvoid main() {}\n",
+			uniforms: []string{"float u_Time vertex,fragment"},
+			link:     []string{"u_Time"},
+		},
+		{
+			name:     "invariant",
+			vertex:   "varying vec2 v_Coord;\ninvariant v_Coord;\nvoid main() {}\n",
+			fragment: "precision mediump float;\nvarying vec2 v_Coord;\nvoid main() {}\n",
+			varyings: []string{"vec2 v_Coord vertex,fragment"},
+			link:     []string{"v_Coord"},
+		},
+		{
+			// link errors are only checked between shaders which compile
+			name:     "compile error",
+			vertex:   "void main() {\n",
+			fragment: "precision mediump float;\nvarying vec3 v_Normal;\nvoid main() {}\n",
+			varyings: []string{"vec3 v_Normal fragment"},
+			compile:  []string{"vertex"},
+		},
+	}
+
+	describe := func(vars []*ReflectVariable) []string {
+		var ret []string
+
+		for _, v := range vars {
+			s := v.Type + " " + v.Name
+
+			if len(v.ArraySize) != 0 {
+				s += "[" + v.ArraySize + "]"
+			}
+
+			ret = append(ret, s+" "+strings.Join(v.Shaders, ","))
+		}
+
+		return ret
+	}
+
+	check := func(name string, what string, got []string, expected []string) {
+		if strings.Join(got, "; ") != strings.Join(expected, "; ") {
+			t.Errorf("%s: expected %s %q, but got %q", name, what, expected, got)
+		}
+	}
+
+	for _, test := range tests {
+		r, err := ReflectProgram(&Program{
+			Name:     "default",
+			Vertex:   test.vertex,
+			Fragment: test.fragment,
+		})
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		check(test.name, "attributes", describe(r.Attributes), test.attributes)
+		check(test.name, "uniforms", describe(r.Uniforms), test.uniforms)
+		check(test.name, "varyings", describe(r.Varyings), test.varyings)
+
+		var link, compile []string
+
+		for _, e := range r.LinkErrors {
+			link = append(link, e.Name)
+		}
+
+		for _, e := range r.CompileErrors {
+			compile = append(compile, e.Shader)
+		}
+
+		check(test.name, "link errors", link, test.link)
+		check(test.name, "compile errors", compile, test.compile)
+	}
+}
+
+func TestReflectHandler(t *testing.T) {
+	setupTest(t)
+
+	hash := storeTestDocument(t, newTestDocument())
+	w := serveTest(t, "GET", "/d/"+hash+"/reflect.json", nil, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var r DocumentReflection
+
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Hash != hash || len(r.Programs) != 1 {
+		t.Fatalf("unexpected reflection %+v", r)
+	}
+
+	if a := r.Programs[0].Attributes; len(a) != 1 || a[0].Name != "v_Position" || a[0].Type != "vec3" {
+		t.Errorf("unexpected attributes %+v", a)
+	}
+
+	if w := serveTest(t, "GET", "/d/0000/reflect.json", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown document, but got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type RekeyCommand struct {
+}
+
+// rekey moves all blobs in s which are stored under a legacy hash to their
+// full strength hash. The new blob is created before the alias is recorded and
+// the old blob is removed, so rekeying can safely be restarted when
+// interrupted.
+func (c *RekeyCommand) rekey(s *Storage) (int, error) {
+	var legacy []string
+
+	err := s.List(func(hash string, info BlobInfo) error {
+		if hasher.IsLegacyHash(hash) {
+			legacy = append(legacy, hash)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, alias := range legacy {
+		data, err := s.Read(alias)
+
+		if err != nil {
+			return 0, err
+		}
+
+		hash, err := s.Store(data)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if err := db.Rekey(s.Directory, alias, hash); err != nil {
+			return 0, err
+		}
+
+		if err := s.Remove(alias); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	return len(legacy), nil
+}
+
+func (c *RekeyCommand) Execute(args []string) error {
+	if err := setup(); err != nil {
+		return err
+	}
+
+	for _, s := range Storages() {
+		n, err := c.rekey(s)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rekeyed %d %s\n", n, s.Directory)
+	}
+
+	return nil
+}
+
+func init() {
+	parser.AddCommand("rekey",
+		"Rekey legacy documents and screenshots",
+		"Moves documents and screenshots stored under legacy short hashes to their full strength hash, keeping the short hash as an alias.",
+		&RekeyCommand{})
+}
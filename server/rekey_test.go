@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	setupTest(t)
+
+	alias := "aB3dE6gH9j"
+	data := []byte("legacy document")
+
+	if err := DocumentStorage.Backend.Create(DocumentStorage.HashPath(alias), data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO gallery (document, state) VALUES (?, ?)", alias, StatePublished); err != nil {
+		t.Fatal(err)
+	}
+
+	var c RekeyCommand
+
+	if n, err := c.rekey(DocumentStorage); err != nil || n != 1 {
+		t.Fatalf("expected 1 rekeyed document, got %d, %v", n, err)
+	}
+
+	hash := hasher.Hash(data)
+
+	if _, err := DocumentStorage.Backend.Read(DocumentStorage.HashPath(alias)); !os.IsNotExist(err) {
+		t.Errorf("expected blob under the legacy hash to be removed, got %v", err)
+	}
+
+	// The legacy hash keeps resolving to the rekeyed blob
+	if read, err := DocumentStorage.Read(alias); err != nil || string(read) != string(data) {
+		t.Errorf("expected legacy hash to resolve, got %q, %v", read, err)
+	}
+
+	var document string
+
+	if err := db.QueryRow("SELECT document FROM gallery").Scan(&document); err != nil || document != hash {
+		t.Errorf("expected gallery to reference %s, got %s, %v", hash, document, err)
+	}
+
+	// Rekeying again finds nothing left to do
+	if n, err := c.rekey(DocumentStorage); err != nil || n != 0 {
+		t.Errorf("expected nothing to rekey, got %d, %v", n, err)
+	}
+}
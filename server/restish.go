@@ -51,23 +51,23 @@ type RestishHandler struct {
 }
 
 func (r RestishVoid) Get(writer http.ResponseWriter, req *http.Request) {
-	http.NotFound(writer, req)
+	RespondError(writer, NotFoundError())
 }
 
 func (r RestishVoid) Post(writer http.ResponseWriter, req *http.Request) {
-	http.NotFound(writer, req)
+	RespondError(writer, NotFoundError())
 }
 
 func (r RestishVoid) Put(writer http.ResponseWriter, req *http.Request) {
-	http.NotFound(writer, req)
+	RespondError(writer, NotFoundError())
 }
 
 func (r RestishVoid) Delete(writer http.ResponseWriter, req *http.Request) {
-	http.NotFound(writer, req)
+	RespondError(writer, NotFoundError())
 }
 
 func (r RestishVoid) Options(writer http.ResponseWriter, req *http.Request) {
-	http.NotFound(writer, req)
+	RespondError(writer, NotFoundError())
 }
 
 func (r RestishVoid) RespondJSON(writer http.ResponseWriter, v interface{}) {
@@ -79,6 +79,10 @@ func (r RestishVoid) RespondJSON(writer http.ResponseWriter, v interface{}) {
 	}
 }
 
+func (r RestishVoid) RespondError(writer http.ResponseWriter, err error) {
+	RespondError(writer, err)
+}
+
 func NewRestishHandler(r Restish) http.Handler {
 	return RestishHandler{
 		restish: r,
@@ -98,6 +102,6 @@ func (r RestishHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request)
 	case "DELETE":
 		r.restish.Delete(writer, req)
 	default:
-		http.NotFound(writer, req)
+		RespondError(writer, NotFoundError())
 	}
 }
@@ -0,0 +1,438 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Options struct {
+	Endpoint  string `long:"s3-endpoint" description:"The S3 compatible endpoint to store data at (e.g. https://s3.amazonaws.com)"`
+	Bucket    string `long:"s3-bucket" description:"The S3 bucket to store data in"`
+	Region    string `long:"s3-region" description:"The S3 region of the bucket" default:"us-east-1"`
+	Prefix    string `long:"s3-prefix" description:"A key prefix under which all data is stored in the bucket"`
+	AccessKey string `long:"s3-access-key" description:"The S3 access key" env:"AWS_ACCESS_KEY_ID"`
+	SecretKey string `long:"s3-secret-key" description:"The S3 secret key" env:"AWS_SECRET_ACCESS_KEY"`
+}
+
+// S3Backend stores blobs as objects in a bucket of an S3 compatible object
+// store. Requests are made path-style and signed with AWS signature version 4,
+// which is supported by S3 itself as well as local stand-ins such as minio.
+type S3Backend struct {
+	Options S3Options
+	Prefix  string
+
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+func NewS3Backend(opts S3Options, directory string) (*S3Backend, error) {
+	if len(opts.Endpoint) == 0 {
+		return nil, fmt.Errorf("No S3 endpoint specified")
+	}
+
+	if len(opts.Bucket) == 0 {
+		return nil, fmt.Errorf("No S3 bucket specified")
+	}
+
+	u, err := url.Parse(opts.Endpoint)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid S3 endpoint: %v", err)
+	}
+
+	return &S3Backend{
+		Options: opts,
+		Prefix:  strings.TrimPrefix(path.Join(opts.Prefix, directory), "/") + "/",
+
+		endpoint: u,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+func (s *S3Backend) Create(name string, data []byte) error {
+	header := http.Header{}
+
+	// Only create the object if it does not exist yet
+	header.Set("If-None-Match", "*")
+
+	resp, err := s.do("PUT", s.Prefix+name, nil, header, data)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		if err := s.touch(name); err != nil {
+			return err
+		}
+
+		return &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	default:
+		return s.error("create", name, resp)
+	}
+}
+
+// touch refreshes the modification time of an object by copying it onto
+// itself, which S3 only allows when replacing its metadata
+func (s *S3Backend) touch(name string) error {
+	header := http.Header{}
+
+	header.Set("X-Amz-Copy-Source", s3EscapePath("/"+s.Options.Bucket+"/"+s.Prefix+name))
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+
+	resp, err := s.do("PUT", s.Prefix+name, nil, header, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.error("touch", name, resp)
+	}
+
+	return nil
+}
+
+func (s *S3Backend) Read(name string) ([]byte, error) {
+	resp, err := s.do("GET", s.Prefix+name, nil, nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	default:
+		return nil, s.error("read", name, resp)
+	}
+}
+
+func (s *S3Backend) Exists(name string) (bool, error) {
+	resp, err := s.do("HEAD", s.Prefix+name, nil, nil, nil)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s.error("exists", name, resp)
+	}
+}
+
+func (s *S3Backend) Delete(name string) error {
+	resp, err := s.do("DELETE", s.Prefix+name, nil, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return &os.PathError{Op: "delete", Path: name, Err: os.ErrNotExist}
+	default:
+		return s.error("delete", name, resp)
+	}
+}
+
+func (s *S3Backend) List(fn func(info BlobInfo) error) error {
+	token := ""
+
+	for {
+		query := url.Values{}
+
+		query.Set("list-type", "2")
+		query.Set("prefix", s.Prefix)
+
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+
+		result, err := s.list(query)
+
+		if err != nil {
+			return err
+		}
+
+		for _, c := range result.Contents {
+			info := BlobInfo{
+				Name:    strings.TrimPrefix(c.Key, s.Prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			}
+
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated {
+			return nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Backend) list(query url.Values) (*s3ListBucketResult, error) {
+	resp, err := s.do("GET", "", query, nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.error("list", s.Prefix, resp)
+	}
+
+	var result s3ListBucketResult
+
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (s *S3Backend) error(op string, name string, resp *http.Response) error {
+	var serr s3Error
+
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	if err := xml.Unmarshal(data, &serr); err != nil || len(serr.Code) == 0 {
+		return fmt.Errorf("S3 %s of %s failed: %s", op, name, resp.Status)
+	}
+
+	return fmt.Errorf("S3 %s of %s failed: %s (%s)", op, name, serr.Message, serr.Code)
+}
+
+func (s *S3Backend) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+
+	u.Path = path.Join("/", u.Path, s.Options.Bucket)
+
+	if len(key) != 0 {
+		u.Path += "/" + key
+	}
+
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS signature version 4 Authorization header to req
+func (s *S3Backend) sign(req *http.Request, body []byte, now time.Time) {
+	const algorithm = "AWS4-HMAC-SHA256"
+
+	payloadHash := sha256.Sum256(body)
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// All x-amz headers have to be signed
+	signed := []string{"host"}
+
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			signed = append(signed, k)
+		}
+	}
+
+	sort.Strings(signed)
+
+	canonicalHeaders := ""
+
+	for _, k := range signed {
+		if k == "host" {
+			canonicalHeaders += "host:" + req.URL.Host + "\n"
+		} else {
+			canonicalHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+		}
+	}
+
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Options.Region, "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+s.Options.SecretKey), date)
+	key = s3HMAC(key, s.Options.Region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.Options.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of
+// RFC 3986, as required for signing
+func s3Escape(s string) string {
+	var b bytes.Buffer
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
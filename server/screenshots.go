@@ -29,11 +29,22 @@
 
 package main
 
-var ScreenshotsStorage = Storage{
+import (
+	"bytes"
+	"image/png"
+)
+
+var ScreenshotsStorage = &Storage{
 	Directory:   "screenshots",
 	ContentType: "image/png",
+	Check:       checkScreenshot,
+}
+
+func checkScreenshot(data []byte) error {
+	_, err := png.Decode(bytes.NewReader(data))
+	return err
 }
 
 func init() {
-	router.Handle("/s/{id:[A-Za-z0-9]+}.png", MakeHandler(ScreenshotsStorage, WrapCompress|WrapCORS))
+	router.Handle("/s/{id:[A-Za-z0-9]+}.png", MakeHandler(ScreenshotsStorage, WrapCORS))
 }
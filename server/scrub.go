@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+type ScrubCommand struct {
+	DryRun bool `short:"n" long:"dry-run" description:"Only report corrupt documents and screenshots, do not quarantine them"`
+}
+
+// ScrubProblem describes a corrupt blob found while scrubbing. Problems are
+// reported as one JSON object per line on stdout.
+type ScrubProblem struct {
+	Storage     string `json:"storage"`
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	Problem     string `json:"problem"`
+	Detail      string `json:"detail,omitempty"`
+	Quarantined bool   `json:"quarantined"`
+}
+
+// check verifies a single blob, returning a problem description and detail
+// if it is corrupt, or an empty problem if it is fine. Blobs stored under a
+// legacy hash cannot be rehashed and only have their contents checked.
+func (c *ScrubCommand) check(s *Storage, hash string, data []byte) (string, string) {
+	if !hasher.IsLegacyHash(hash) {
+		if h := hasher.Hash(data); h != hash {
+			return "hash mismatch", fmt.Sprintf("contents hash to %s", h)
+		}
+	}
+
+	if s.Check != nil {
+		if err := s.Check(data); err != nil {
+			return "invalid content", err.Error()
+		}
+	}
+
+	return "", ""
+}
+
+// quarantine moves a blob out of the storage, into the quarantine directory
+// of its backend
+func (c *ScrubCommand) quarantine(s *Storage, name string, data []byte) error {
+	q, err := NewBlobBackend(path.Join("quarantine", s.Directory))
+
+	if err != nil {
+		return err
+	}
+
+	if err := q.Create(name, data); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return s.Backend.Delete(name)
+}
+
+// scrubBlob checks the blob stored at name, which is decoded with decode if
+// it is an encoded variant. It returns whether the blob was corrupt.
+func (c *ScrubCommand) scrubBlob(s *Storage, name string, hash string, decode func([]byte) ([]byte, error), enc *json.Encoder) (bool, error) {
+	data, err := s.Backend.Read(name)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	var problem, detail string
+
+	content := data
+
+	if decode != nil {
+		if content, err = decode(data); err != nil {
+			problem, detail = "invalid encoding", err.Error()
+		}
+	}
+
+	if len(problem) == 0 {
+		problem, detail = c.check(s, hash, content)
+	}
+
+	if len(problem) == 0 {
+		return false, nil
+	}
+
+	p := ScrubProblem{
+		Storage: s.Directory,
+		Name:    name,
+		Hash:    hash,
+		Size:    int64(len(data)),
+		Problem: problem,
+		Detail:  detail,
+	}
+
+	if !c.DryRun {
+		if err := c.quarantine(s, name, data); err != nil {
+			return false, err
+		}
+
+		p.Quarantined = true
+	}
+
+	return true, enc.Encode(p)
+}
+
+func (c *ScrubCommand) scrub(s *Storage, enc *json.Encoder) (int, int, error) {
+	var blobs []BlobInfo
+
+	err := s.List(func(hash string, info BlobInfo) error {
+		blobs = append(blobs, info)
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	corrupt := 0
+
+	for _, info := range blobs {
+		hash := s.PathHash(info.Name)
+
+		if bad, err := c.scrubBlob(s, info.Name, hash, nil, enc); err != nil {
+			return 0, 0, err
+		} else if bad {
+			corrupt++
+		}
+
+		for _, e := range s.Encodings {
+			if bad, err := c.scrubBlob(s, info.Name+e.Extension, hash, e.Decompress, enc); err != nil {
+				return 0, 0, err
+			} else if bad {
+				corrupt++
+			}
+		}
+	}
+
+	return len(blobs), corrupt, nil
+}
+
+func (c *ScrubCommand) Execute(args []string) error {
+	if err := setup(); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	corrupt := 0
+
+	for _, s := range Storages() {
+		n, nc, err := c.scrub(s, enc)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Scrubbed %d %s, %d corrupt\n", n, s.Directory, nc)
+		corrupt += nc
+	}
+
+	if corrupt != 0 {
+		return fmt.Errorf("Found %d corrupt documents and screenshots", corrupt)
+	}
+
+	return nil
+}
+
+func init() {
+	parser.AddCommand("scrub",
+		"Verify the integrity of documents and screenshots",
+		"Rehashes every document and screenshot and checks that it is stored under its hash, that documents are valid and that screenshots are valid PNG images. Corrupt data is moved to the quarantine directory and reported as one JSON object per line.",
+		&ScrubCommand{})
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestScrubCheck(t *testing.T) {
+	valid := testPNG(t)
+
+	tests := []struct {
+		hash    string
+		data    []byte
+		problem string
+	}{
+		{hasher.Hash(valid), valid, ""},
+		{hasher.Hash([]byte("other")), valid, "hash mismatch"},
+		{hasher.Hash([]byte("not a png")), []byte("not a png"), "invalid content"},
+		{"aB3dE6gH9j", valid, ""},
+		{"aB3dE6gH9j", []byte("not a png"), "invalid content"},
+	}
+
+	var c ScrubCommand
+
+	for i, test := range tests {
+		if problem, _ := c.check(ScreenshotsStorage, test.hash, test.data); problem != test.problem {
+			t.Errorf("%d: expected problem %q, got %q", i, test.problem, problem)
+		}
+	}
+}
+
+func TestScrub(t *testing.T) {
+	setupTest(t)
+
+	valid := storeTestBlob(t, ScreenshotsStorage, string(testPNG(t)))
+	corrupt := hasher.Hash([]byte("original"))
+
+	if err := ScreenshotsStorage.Backend.Create(ScreenshotsStorage.HashPath(corrupt), testPNG(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	scrub := func(dryRun bool) []ScrubProblem {
+		var buf bytes.Buffer
+
+		c := ScrubCommand{DryRun: dryRun}
+		n, nc, err := c.scrub(ScreenshotsStorage, json.NewEncoder(&buf))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 2 || nc != 1 {
+			t.Fatalf("expected 1 of 2 blobs to be corrupt, got %d of %d", nc, n)
+		}
+
+		var problems []ScrubProblem
+		scanner := bufio.NewScanner(&buf)
+
+		for scanner.Scan() {
+			var p ScrubProblem
+
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+
+			problems = append(problems, p)
+		}
+
+		return problems
+	}
+
+	if problems := scrub(true); len(problems) != 1 || problems[0].Hash != corrupt || problems[0].Quarantined {
+		t.Fatalf("unexpected problems of a dry run: %v", problems)
+	}
+
+	if problems := scrub(false); len(problems) != 1 || problems[0].Problem != "hash mismatch" || !problems[0].Quarantined {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	if _, err := ScreenshotsStorage.Read(corrupt); !os.IsNotExist(err) {
+		t.Errorf("expected corrupt blob to be removed, got %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dataRoot, "quarantine", "screenshots", ScreenshotsStorage.HashPath(corrupt))); err != nil {
+		t.Errorf("expected corrupt blob to be quarantined: %v", err)
+	}
+
+	if _, err := ScreenshotsStorage.Read(valid); err != nil {
+		t.Errorf("expected valid blob to be kept: %v", err)
+	}
+}
@@ -52,12 +52,28 @@ type Options struct {
 	PublicHost     string   `short:"p" long:"public-host" description:"The public playground host address (e.g. http://webgl.example.com/)" default:"http://localhost:8000/"`
 	SSLCert        string   `long:"ssl-cert" description:"SSL certificate file"`
 	SSLKey         string   `long:"ssl-key" description:"SSL key file"`
+	Storage        string   `long:"storage" description:"Where to store documents and screenshots" choice:"filesystem" choice:"s3" default:"filesystem"`
+	ShardLayout    string   `long:"shard-layout" description:"Number of hash characters per directory level used to store documents and screenshots (e.g. 2/2)" default:"2"`
+	ShardFallback  string   `long:"shard-fallback-layout" description:"Shard layout in which to look for documents and screenshots which have not been moved to the current shard layout yet"`
+	CacheSize      int      `long:"cache-size" description:"Size in megabytes of the in memory cache of documents and screenshots (disabled when 0)" default:"64"`
+	AdminListen    string   `long:"admin-listen" description:"The address to serve internal statistics on, which should not be publicly accessible (disabled when empty)"`
+
+	S3       S3Options       `group:"S3 Storage Options"`
+	GC       GCOptions       `group:"Garbage Collection Options"`
+	Expiry   ExpiryOptions   `group:"Expiry Options"`
+	Assets   AssetOptions    `group:"Asset Options"`
+	Trending TrendingOptions `group:"Trending Options"`
 
 	CORSDomainMap map[string]bool
 }
 
 var router = mux.NewRouter()
+
+// adminRouter serves internal information about the server, and is only
+// served on the admin listener
+var adminRouter = mux.NewRouter()
 var options Options
+var parser = flags.NewParser(&options, flags.Default)
 var dataRoot string
 var siteRoot string
 
@@ -72,9 +88,24 @@ func absPath(p string) string {
 type LimitedRequestHandler struct {
 }
 
+// maxRequestSize returns the maximum size of the body of a request. Requests
+// are limited to 2MB, except for asset uploads, which are limited by the
+// maximum asset size. Their handler reads one byte more than allowed to
+// detect assets which are too large.
+func maxRequestSize(req *http.Request) int64 {
+	size := int64(1 << 21)
+
+	if req.URL.Path == "/a/new" {
+		if asset := int64(options.Assets.MaxSize<<10) + 1; asset > size {
+			size = asset
+		}
+	}
+
+	return size
+}
+
 func (l LimitedRequestHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
-	// Limit requests to 2MB
-	req.Body = http.MaxBytesReader(wr, req.Body, 1<<21)
+	req.Body = http.MaxBytesReader(wr, req.Body, maxRequestSize(req))
 	router.ServeHTTP(wr, req)
 }
 
@@ -106,11 +137,31 @@ func MakeHandler(h interface{}, wrap HandlerWrappers) http.Handler {
 	return hh
 }
 
+// setup opens the data storage and the gallery database, which are used by
+// the server as well as by all the commands
+func setup() error {
+	dataRoot = absPath(options.Data)
+
+	if err := OpenStorages(); err != nil {
+		return fmt.Errorf("Error while opening storage: %s", err)
+	}
+
+	db.Open()
+	return nil
+}
+
 func main() {
-	if _, err := flags.Parse(&options); err != nil {
+	parser.SubcommandsOptional = true
+
+	if _, err := parser.Parse(); err != nil {
 		os.Exit(1)
 	}
 
+	// Commands have already been executed by the parser
+	if parser.Active != nil {
+		return
+	}
+
 	if options.Listen == "" {
 		if options.SSLCert != "" && options.SSLKey != "" {
 			options.Listen = ":8443"
@@ -129,8 +180,6 @@ func main() {
 		options.CORSDomainMap[domain] = true
 	}
 
-	dataRoot = absPath(options.Data)
-
 	if options.SiteData != "-" {
 		siteRoot = absPath(options.SiteData)
 
@@ -138,7 +187,40 @@ func main() {
 		router.PathPrefix("/").Handler(MakeHandler(NewRestishHandler(SiteHandler{}), WrapCompress))
 	}
 
-	db.Open()
+	if err := setup(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if options.GC.Interval != 0 {
+		collector := GarbageCollector{
+			Options: options.GC,
+		}
+
+		go collector.run()
+	}
+
+	if options.Expiry.Interval != 0 {
+		go runExpiry(options.Expiry.Interval)
+	}
+
+	if options.Trending.Interval != 0 {
+		if options.Trending.HalfLife <= 0 {
+			fmt.Fprintf(os.Stderr, "The trending half-life has to be positive\n")
+			os.Exit(1)
+		}
+
+		go runTrending(options.Trending)
+	}
+
+	if options.AdminListen != "" {
+		go func() {
+			if err := http.ListenAndServe(options.AdminListen, adminRouter); err != nil {
+				fmt.Fprintf(os.Stderr, "Error while listening on admin address: %s\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	srv := &http.Server{
 		Addr:           options.Listen,
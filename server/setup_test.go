@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// setupTest points the server at a new, empty data directory with all options
+// at their defaults
+func setupTest(t *testing.T) {
+	t.Helper()
+
+	options = Options{}
+
+	if _, err := flags.NewParser(&options, flags.None).ParseArgs(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs of earlier tests must not be served from the cache
+	options.CacheSize = 0
+	blobCache = nil
+
+	dataRoot = t.TempDir()
+
+	if err := OpenStorages(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Open()
+
+	t.Cleanup(func() {
+		db.Close()
+		db.DB = nil
+	})
+}
+
+// serveTest serves a request through the router of the server
+func serveTest(t *testing.T, method string, url string, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, url, body)
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// newTestDocument returns a valid document with a single program
+func newTestDocument() *Document {
+	return &Document{
+		Version:     DocumentVersion,
+		Title:       "Test",
+		Description: "A test document",
+		Programs: []Program{
+			{
+				Version:   ProgramVersion,
+				Name:      "default",
+				Vertex:    "attribute vec3 v_Position;\nvoid main() {\n\tgl_Position = vec4(v_Position, 1.0);\n}\n",
+				Fragment:  "precision mediump float;\nvoid main() {\n\tgl_FragColor = vec4(1.0);\n}\n",
+				IsDefault: true,
+			},
+		},
+		Javascript: "var c = this;\n",
+		Authors: []Author{
+			{Name: "Author", License: "CC BY", Year: 2014},
+		},
+	}
+}
+
+// storeTestDocument stores doc without recording any share of it
+func storeTestDocument(t *testing.T, doc *Document) string {
+	t.Helper()
+
+	hash, err := doc.Store()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+// shareTestDocument prepares and stores a copy of the test document with the
+// given title and parent, shared with the given visibility
+func shareTestDocument(t *testing.T, title string, parent string, visibility string, keys ...string) (string, DocumentShare) {
+	t.Helper()
+
+	doc := newTestDocument()
+	doc.Title = title
+	doc.Parent = parent
+
+	share, err := NewDocumentShare(visibility, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doc.Prepare(doc.Authors[0], keys); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := doc.StoreNew(share)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash, share
+}
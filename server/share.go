@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Visibilities of shared documents. Public documents are listed in the fork
+// trees of their parents, unlisted documents can only be read by those who
+// know their hash, and private documents can only be read with their key.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+type ExpiryOptions struct {
+	Interval time.Duration `long:"expiry-interval" description:"Interval at which to remove expired documents in the background (disabled when 0)" default:"10m"`
+}
+
+// DocumentShare records how a document was shared. Identical documents are
+// stored only once, so a document can have several shares, and it can be
+// read as long as any of them allows it. Documents without any shares were
+// shared before documents had a visibility, and are public.
+type DocumentShare struct {
+	Visibility string
+	Key        string
+
+	// Expires is the time at which the share expires, or the zero time if
+	// it never expires
+	Expires time.Time
+}
+
+func (s *DocumentShare) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// Listed returns whether the document may be listed through the share
+func (s *DocumentShare) Listed(now time.Time) bool {
+	return s.Visibility == VisibilityPublic && !s.Expired(now)
+}
+
+// Permits returns whether the share allows reading the document with one of
+// the given keys
+func (s *DocumentShare) Permits(keys []string, now time.Time) bool {
+	if s.Expired(now) {
+		return false
+	}
+
+	if s.Visibility != VisibilityPrivate {
+		return true
+	}
+
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.Key)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewDocumentShare creates a share of a newly shared document, generating a
+// key for private documents
+func NewDocumentShare(visibility string, expires *time.Time) (DocumentShare, error) {
+	share := DocumentShare{
+		Visibility: visibility,
+	}
+
+	switch visibility {
+	case "":
+		share.Visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityUnlisted:
+	case VisibilityPrivate:
+		key := make([]byte, 16)
+
+		if _, err := rand.Read(key); err != nil {
+			return share, err
+		}
+
+		share.Key = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return share, InvalidError(ErrorInvalidField, "visibility", "Invalid visibility %s", visibility)
+	}
+
+	if expires != nil {
+		if !expires.After(time.Now()) {
+			return share, InvalidError(ErrorInvalidField, "expires", "Expiry time is in the past")
+		}
+
+		share.Expires = expires.UTC()
+	}
+
+	return share, nil
+}
+
+// documentAccess checks whether a document can be read with the given keys,
+// and returns whether access to it is restricted, which is the case unless
+// it is public and never expires. Documents which cannot be read are
+// reported as not found.
+func documentAccess(keys []string, hash string) (bool, error) {
+	shares, err := db.DocumentShares(hash)
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(shares) == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	permitted := false
+
+	for _, s := range shares {
+		if s.Visibility == VisibilityPublic && s.Expires.IsZero() {
+			return false, nil
+		}
+
+		if s.Permits(keys, now) {
+			permitted = true
+		}
+	}
+
+	if !permitted {
+		return false, NotFoundError()
+	}
+
+	return true, nil
+}
+
+// documentUpgraded shares an upgraded document in the same way as the
+// document it was upgraded from
+func documentUpgraded(from string, hash string, existed bool) error {
+	return db.CopyDocumentShares(from, hash, existed)
+}
+
+// requestKeys returns the document keys given in the key query parameters
+// of a request
+func requestKeys(req *http.Request) []string {
+	return req.URL.Query()["key"]
+}
+
+func documentRequestAccess(req *http.Request, hash string) (bool, error) {
+	return documentAccess(requestKeys(req), hash)
+}
+
+// LoadSharedDocument loads a document on behalf of a request, reporting
+// documents which may not be read by the request as not found
+func LoadSharedDocument(req *http.Request, hash string) (*Document, error) {
+	if _, err := documentRequestAccess(req, hash); err != nil {
+		return nil, err
+	}
+
+	return LoadDocument(hash)
+}
+
+// documentListed returns whether a document may be listed, e.g. in the fork
+// tree of its parent
+func documentListed(hash string) (bool, error) {
+	shares, err := db.DocumentShares(hash)
+
+	if err != nil || len(shares) == 0 {
+		return err == nil, err
+	}
+
+	now := time.Now()
+
+	for _, s := range shares {
+		if s.Listed(now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RemoveExpiredDocuments removes the documents of which all shares have
+// expired. Documents which are still used by the gallery are kept, and
+// become public.
+func RemoveExpiredDocuments() (int, error) {
+	expired, err := db.ExpiredDocuments(time.Now())
+
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	documents, _, err := db.GalleryReferences()
+
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for _, hash := range expired {
+		if !documents[hash] {
+			if err := DocumentStorage.Remove(hash); err != nil && !os.IsNotExist(err) {
+				return n, err
+			}
+
+			if err := db.RemoveDocument(hash); err != nil {
+				return n, err
+			}
+
+			n++
+		}
+
+		if err := db.RemoveDocumentShares(hash); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func runExpiry(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		n, err := RemoveExpiredDocuments()
+
+		if err != nil {
+			log.Printf("Failed to remove expired documents: %v", err)
+			continue
+		}
+
+		if n != 0 {
+			log.Printf("Removed %d expired documents", n)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDocumentSharePermits(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		share  DocumentShare
+		keys   []string
+		permit bool
+		listed bool
+	}{
+		{"public", DocumentShare{Visibility: VisibilityPublic}, nil, true, true},
+		{"unlisted", DocumentShare{Visibility: VisibilityUnlisted}, nil, true, false},
+		{"private without key", DocumentShare{Visibility: VisibilityPrivate, Key: "k"}, nil, false, false},
+		{"private with wrong key", DocumentShare{Visibility: VisibilityPrivate, Key: "k"}, []string{"x"}, false, false},
+		{"private with key", DocumentShare{Visibility: VisibilityPrivate, Key: "k"}, []string{"x", "k"}, true, false},
+		{"public expiring", DocumentShare{Visibility: VisibilityPublic, Expires: future}, nil, true, true},
+		{"public expired", DocumentShare{Visibility: VisibilityPublic, Expires: past}, nil, false, false},
+		{"private expired", DocumentShare{Visibility: VisibilityPrivate, Key: "k", Expires: past}, []string{"k"}, false, false},
+	}
+
+	for _, tt := range tests {
+		if permit := tt.share.Permits(tt.keys, now); permit != tt.permit {
+			t.Errorf("%s: expected permitted %v, got %v", tt.name, tt.permit, permit)
+		}
+
+		if listed := tt.share.Listed(now); listed != tt.listed {
+			t.Errorf("%s: expected listed %v, got %v", tt.name, tt.listed, listed)
+		}
+	}
+}
+
+func TestNewDocumentShare(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		visibility string
+		expires    *time.Time
+		expected   string
+		key        bool
+		err        string
+	}{
+		{"", nil, VisibilityPublic, false, ""},
+		{VisibilityPublic, &future, VisibilityPublic, false, ""},
+		{VisibilityUnlisted, nil, VisibilityUnlisted, false, ""},
+		{VisibilityPrivate, nil, VisibilityPrivate, true, ""},
+		{"secret", nil, "", false, "visibility"},
+		{VisibilityPublic, &past, "", false, "expires"},
+	}
+
+	for _, tt := range tests {
+		share, err := NewDocumentShare(tt.visibility, tt.expires)
+
+		if len(tt.err) != 0 {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorInvalidField || aerr.Field != tt.err {
+				t.Errorf("%s: expected invalid %s, got %v", tt.visibility, tt.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.visibility, err)
+			continue
+		}
+
+		if share.Visibility != tt.expected {
+			t.Errorf("%s: expected visibility %s, got %s", tt.visibility, tt.expected, share.Visibility)
+		}
+
+		if (len(share.Key) != 0) != tt.key {
+			t.Errorf("%s: expected key %v, got %q", tt.visibility, tt.key, share.Key)
+		}
+
+		if tt.expires != nil && !share.Expires.Equal(*tt.expires) {
+			t.Errorf("%s: expected expiry %v, got %v", tt.visibility, *tt.expires, share.Expires)
+		}
+	}
+}
+
+func TestDocumentAccess(t *testing.T) {
+	setupTest(t)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	private := DocumentShare{Visibility: VisibilityPrivate, Key: "k"}
+
+	tests := []struct {
+		name       string
+		shares     []DocumentShare
+		keys       []string
+		restricted bool
+		found      bool
+	}{
+		{"share-less", nil, nil, false, true},
+		{"public", []DocumentShare{{Visibility: VisibilityPublic}}, nil, false, true},
+		{"unlisted", []DocumentShare{{Visibility: VisibilityUnlisted}}, nil, true, true},
+		{"private without key", []DocumentShare{private}, nil, false, false},
+		{"private with wrong key", []DocumentShare{private}, []string{"x"}, false, false},
+		{"private with key", []DocumentShare{private}, []string{"k"}, true, true},
+		{"public expiring", []DocumentShare{{Visibility: VisibilityPublic, Expires: future}}, nil, true, true},
+		{"public expired", []DocumentShare{{Visibility: VisibilityPublic, Expires: past}}, nil, false, false},
+		{"private and public", []DocumentShare{private, {Visibility: VisibilityPublic}}, nil, false, true},
+		{"private and expired", []DocumentShare{private, {Visibility: VisibilityUnlisted, Expires: past}}, nil, false, false},
+		{"private and expired with key", []DocumentShare{private, {Visibility: VisibilityUnlisted, Expires: past}}, []string{"k"}, true, true},
+		{"expired and expiring", []DocumentShare{{Visibility: VisibilityPublic, Expires: past}, {Visibility: VisibilityUnlisted, Expires: future}}, nil, true, true},
+	}
+
+	for _, tt := range tests {
+		hash := storeTestBlob(t, DocumentStorage, tt.name)
+
+		for _, share := range tt.shares {
+			if err := db.AddDocumentShare(hash, share, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		restricted, err := documentAccess(tt.keys, hash)
+
+		if !tt.found {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorNotFound {
+				t.Errorf("%s: expected not found, got %v", tt.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if restricted != tt.restricted {
+			t.Errorf("%s: expected restricted %v, got %v", tt.name, tt.restricted, restricted)
+		}
+	}
+}
+
+func TestShareStoredDocument(t *testing.T) {
+	setupTest(t)
+
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		stored     bool
+		share      DocumentShare
+		restricted bool
+		found      bool
+	}{
+		{"share-less shared privately", true, DocumentShare{Visibility: VisibilityPrivate, Key: "k"}, false, true},
+		{"share-less shared expiring", true, DocumentShare{Visibility: VisibilityUnlisted, Expires: past}, false, true},
+		{"new shared privately", false, DocumentShare{Visibility: VisibilityPrivate, Key: "k"}, false, false},
+		{"new shared expiring", false, DocumentShare{Visibility: VisibilityUnlisted, Expires: past}, false, false},
+	}
+
+	for _, tt := range tests {
+		doc := newTestDocument()
+		doc.Title = tt.name
+
+		if tt.stored {
+			storeTestDocument(t, doc)
+		}
+
+		hash, err := doc.StoreNew(tt.share)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restricted, err := documentAccess(nil, hash)
+
+		if !tt.found {
+			if aerr, ok := err.(*APIError); !ok || aerr.Code != ErrorNotFound {
+				t.Errorf("%s: expected not found, got %v", tt.name, err)
+			}
+		} else if err != nil || restricted != tt.restricted {
+			t.Errorf("%s: expected restricted %v, got %v, %v", tt.name, tt.restricted, restricted, err)
+		}
+
+		expired, err := db.ExpiredDocuments(time.Now())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		isExpired := false
+
+		for _, e := range expired {
+			if e == hash {
+				isExpired = true
+			}
+		}
+
+		if expect := !tt.stored && !tt.share.Expires.IsZero(); isExpired != expect {
+			t.Errorf("%s: expected expired %v, got %v", tt.name, expect, isExpired)
+		}
+	}
+}
+
+func TestShareHandlers(t *testing.T) {
+	setupTest(t)
+
+	doc := newTestDocument()
+	public := storeTestDocument(t, doc)
+
+	// Sharing the stored document privately again keeps it public
+	share, _ := NewDocumentShare(VisibilityPrivate, nil)
+
+	if _, err := doc.StoreNew(share); err != nil {
+		t.Fatal(err)
+	}
+
+	private, pshare := shareTestDocument(t, "Private", "", VisibilityPrivate)
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/d/" + public + ".json", http.StatusOK},
+		{"/d/" + private + ".json", http.StatusNotFound},
+		{"/d/" + private + ".json?key=x", http.StatusNotFound},
+		{"/d/" + private + ".json?key=" + pshare.Key, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if rec := serveTest(t, "GET", tt.url, nil, nil); rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.url, tt.code, rec.Code)
+		}
+	}
+}
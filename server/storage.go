@@ -2,90 +2,555 @@ package main
 
 import (
 	"bytes"
-	"io/ioutil"
-	"math/rand"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// BlobBackend is the place where the actual data of a Storage ends up. Blobs
+// are addressed by slash separated names relative to the storage directory
+// and are never modified once created. Backends report missing blobs with an
+// error for which os.IsNotExist is true, and existing blobs on Create with an
+// error for which os.IsExist is true. Creating an existing blob refreshes its
+// modification time, so that blobs which are stored again are not collected
+// as garbage before they are referenced.
+type BlobBackend interface {
+	Create(name string, data []byte) error
+	Read(name string) ([]byte, error)
+	Exists(name string) (bool, error)
+	Delete(name string) error
+	List(fn func(info BlobInfo) error) error
+}
+
+type BlobInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
 type Storage struct {
 	RestishVoid
 
 	Directory   string
 	ContentType string
+
+	// ContentTypeOf, when not nil, returns the content type of a blob from
+	// its data, for storages of blobs in several formats. It overrides
+	// ContentType, and cannot be used with Encodings.
+	ContentTypeOf func(data []byte) string
+
+	// Check, when not nil, verifies that data is valid content for the storage
+	Check func(data []byte) error
+
+	// Upgrade, when not nil, upgrades outdated data. It returns the upgraded
+	// data and whether data was outdated.
+	Upgrade func(data []byte) ([]byte, bool, error)
+
+	// Upgraded, when not nil, is called when the blob with the given hash has
+	// been upgraded and stored under a new hash, along with whether a blob
+	// with the new hash existed before
+	Upgraded func(from string, hash string, existed bool) error
+
+	// Access, when not nil, checks whether a request may read the blob with
+	// the given hash, and returns whether access to it is restricted. Blobs
+	// which may not be read should be reported as not found, and restricted
+	// blobs are not cached publicly.
+	Access func(req *http.Request, hash string) (bool, error)
+
+	// Encodings in which blobs are additionally stored and served
+	Encodings []Encoding
+
+	// Layout is the shard layout blobs are stored in. While blobs are being
+	// moved to a new layout, blobs which are not found are looked up in the
+	// fallback layout.
+	Layout         ShardLayout
+	FallbackLayout ShardLayout
+
+	Backend BlobBackend
+	Cache   *BlobCache
 }
 
-func (s Storage) FullPath(parts ...string) string {
-	args := append([]string{dataRoot, s.Directory}, parts...)
-	return path.Join(args...)
+func NewBlobBackend(directory string) (BlobBackend, error) {
+	switch options.Storage {
+	case "", "filesystem":
+		backend := NewFileBackend(path.Join(dataRoot, directory))
+
+		if n, err := backend.RemoveTemporary(); err != nil {
+			return nil, err
+		} else if n != 0 {
+			log.Printf("Removed %d partially written files from %s", n, directory)
+		}
+
+		return backend, nil
+	case "s3":
+		return NewS3Backend(options.S3, directory)
+	default:
+		return nil, fmt.Errorf("Unknown storage backend %s", options.Storage)
+	}
 }
 
-func (s Storage) HashPath(hash string) string {
-	return s.FullPath(hash[0:2], hash[2:])
+// Storages returns all the storages in which the server keeps data
+func Storages() []*Storage {
+	return []*Storage{DocumentStorage, ScreenshotsStorage, AssetsStorage}
 }
 
-func (s Storage) Store(data []byte) (string, error) {
-	hash := hasher.Hash(data)
-	extraData := []byte{}
+func OpenStorages() error {
+	if options.CacheSize > 0 {
+		blobCache = NewBlobCache(int64(options.CacheSize) << 20)
+	}
 
-	for {
-		d := s.FullPath(hash[0:2])
-		p := path.Join(d, hash[2:])
+	for _, s := range Storages() {
+		if err := s.Open(); err != nil {
+			return err
+		}
 
-		// Try to write at hash
-		os.MkdirAll(d, 0755)
-		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		s.Cache = blobCache
+	}
 
-		if err != nil {
-			if err.(*os.PathError).Err == syscall.EEXIST {
-				// Check if existing file contains the same data
-				fdata, _ := ioutil.ReadFile(p)
-
-				if bytes.Equal(fdata, data) {
-					break
-				}
-
-				// Otherwise it's a hash collision, add some arbitrary data
-				// to hash
-				extraData = append(extraData, byte(rand.Intn(255)))
-				hash = hasher.Hash(data, extraData)
-			} else {
-				return "", err
-			}
-		} else {
-			defer f.Close()
-
-			if _, err := f.Write(data); err != nil {
-				return "", err
-			}
+	return nil
+}
 
-			break
+func (s *Storage) Open() error {
+	layout, err := ParseShardLayout(options.ShardLayout)
+
+	if err != nil {
+		return err
+	}
+
+	s.Layout = layout
+
+	if len(options.ShardFallback) != 0 {
+		if s.FallbackLayout, err = ParseShardLayout(options.ShardFallback); err != nil {
+			return err
+		}
+	}
+
+	backend, err := NewBlobBackend(s.Directory)
+
+	if err != nil {
+		return err
+	}
+
+	s.Backend = backend
+	return nil
+}
+
+func (s *Storage) HashPath(hash string) string {
+	return s.Layout.Path(hash)
+}
+
+// PathHash is the inverse of HashPath
+func (s *Storage) PathHash(name string) string {
+	return strings.Replace(name, "/", "", -1)
+}
+
+// splitVariant splits the name of an encoded variant of a blob into the name
+// of the blob and the extension of the variant
+func (s *Storage) splitVariant(name string) (string, string) {
+	for _, enc := range s.Encodings {
+		if strings.HasSuffix(name, enc.Extension) {
+			return name[:len(name)-len(enc.Extension)], enc.Extension
+		}
+	}
+
+	return name, ""
+}
+
+func (s *Storage) isVariant(name string) bool {
+	_, ext := s.splitVariant(name)
+	return len(ext) != 0
+}
+
+// List calls fn for every blob in the storage, skipping encoded variants
+func (s *Storage) List(fn func(hash string, info BlobInfo) error) error {
+	return s.Backend.List(func(info BlobInfo) error {
+		if s.isVariant(info.Name) {
+			return nil
+		}
+
+		return fn(s.PathHash(info.Name), info)
+	})
+}
+
+func (s *Storage) Store(data []byte) (string, error) {
+	hash := hasher.Hash(data)
+
+	// Blobs are content addressed, so an existing blob has the same data
+	if err := s.Backend.Create(s.HashPath(hash), data); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	for _, enc := range s.Encodings {
+		if _, err := s.storeVariant(hash, enc, data); err != nil {
+			return "", err
 		}
 	}
 
 	return hash, nil
 }
 
-func (s Storage) Get(writer http.ResponseWriter, req *http.Request) {
+func (s *Storage) storeVariant(hash string, enc Encoding, data []byte) ([]byte, error) {
+	encoded, err := enc.Compress(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Backend.Create(s.HashPath(hash)+enc.Extension, encoded); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	return encoded, nil
+}
+
+// Remove deletes the blob with the given hash, together with its variants
+func (s *Storage) Remove(hash string) error {
+	if s.FallbackLayout != nil {
+		if err := s.remove(s.FallbackLayout.Path(hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return s.remove(s.HashPath(hash))
+}
+
+func (s *Storage) remove(name string) error {
+	for _, enc := range s.Encodings {
+		if err := s.removeBlob(name + enc.Extension); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return s.removeBlob(name)
+}
+
+func (s *Storage) cacheKey(name string) string {
+	return s.Directory + "/" + name
+}
+
+// readBlob reads the blob with the given name from the backend, going through
+// the cache if the storage has one
+func (s *Storage) readBlob(name string) ([]byte, error) {
+	if s.Cache == nil {
+		return s.Backend.Read(name)
+	}
+
+	key := s.cacheKey(name)
+
+	if data, ok := s.Cache.Get(key); ok {
+		return data, nil
+	}
+
+	data, err := s.Backend.Read(name)
+
+	if err == nil {
+		s.Cache.Add(key, data)
+	}
+
+	return data, err
+}
+
+func (s *Storage) removeBlob(name string) error {
+	if s.Cache != nil {
+		s.Cache.Remove(s.cacheKey(name))
+	}
+
+	return s.Backend.Delete(name)
+}
+
+// read reads the blob with the given hash and extension. Legacy hashes are
+// resolved through their alias if there is no blob stored under the legacy
+// hash itself. It returns the data and the hash it was found under.
+func (s *Storage) read(hash string, ext string) ([]byte, string, error) {
+	data, err := s.readHash(hash, ext)
+
+	if err == nil || !os.IsNotExist(err) || !hasher.IsLegacyHash(hash) {
+		return data, hash, err
+	}
+
+	alias, aerr := db.ResolveAlias(s.Directory, hash)
+
+	if aerr != nil {
+		return nil, hash, aerr
+	}
+
+	if len(alias) == 0 {
+		return nil, hash, err
+	}
+
+	data, err = s.readHash(alias, ext)
+	return data, alias, err
+}
+
+// readHash reads the blob with the given hash and extension, looking in the
+// fallback layout when it is not stored in the current layout
+func (s *Storage) readHash(hash string, ext string) ([]byte, error) {
+	name := s.HashPath(hash) + ext
+	data, err := s.readBlob(name)
+
+	if err == nil || !os.IsNotExist(err) || s.FallbackLayout == nil {
+		return data, err
+	}
+
+	if data, ferr := s.readBlob(s.FallbackLayout.Path(hash) + ext); ferr == nil || !os.IsNotExist(ferr) {
+		return data, ferr
+	}
+
+	// The blob may have been moved to the current layout in the meantime
+	return s.readBlob(name)
+}
+
+// Exists returns whether the blob with the given hash is stored, in either
+// the current or the fallback layout
+func (s *Storage) Exists(hash string) (bool, error) {
+	if exists, err := s.Backend.Exists(s.HashPath(hash)); exists || err != nil || s.FallbackLayout == nil {
+		return exists, err
+	}
+
+	if exists, err := s.Backend.Exists(s.FallbackLayout.Path(hash)); exists || err != nil {
+		return exists, err
+	}
+
+	// The blob may have been moved to the current layout in the meantime
+	return s.Backend.Exists(s.HashPath(hash))
+}
+
+func (s *Storage) Read(hash string) ([]byte, error) {
+	data, _, err := s.read(hash, "")
+	return data, err
+}
+
+// readEncoded reads the variant of the blob with the given hash in the given
+// encoding. Blobs which were stored before the storage had any encodings do
+// not have a variant yet, in which case it is created from the blob.
+func (s *Storage) readEncoded(hash string, enc Encoding) ([]byte, string, error) {
+	data, found, err := s.read(hash, enc.Extension)
+
+	if err == nil || !os.IsNotExist(err) {
+		return data, found, err
+	}
+
+	data, found, err = s.read(hash, "")
+
+	if err != nil {
+		return nil, found, err
+	}
+
+	data, err = s.storeVariant(found, enc, data)
+	return data, found, err
+}
+
+// ETag returns the strong entity tag of the blob with the given hash, served
+// in the given encoding
+func (s *Storage) ETag(hash string, enc *Encoding) string {
+	if enc != nil {
+		return `"` + hash + "-" + enc.Name + `"`
+	}
+
+	return `"` + hash + `"`
+}
+
+func (s *Storage) setHeaders(h http.Header, hash string, enc *Encoding, restricted bool) {
+	if len(s.ContentType) != 0 {
+		h.Set("Content-Type", s.ContentType)
+	}
+
+	if len(s.Encodings) != 0 {
+		h.Set("Vary", "Accept-Encoding")
+	}
+
+	if enc != nil {
+		h.Set("Content-Encoding", enc.Name)
+	}
+
+	h.Set("ETag", s.ETag(hash, enc))
+
+	// Restricted blobs have to be revalidated, so that access is checked
+	if restricted {
+		h.Set("Cache-Control", "private, no-cache")
+	} else {
+		h.Set("Cache-Control", "max-age=31536000, immutable")
+	}
+}
+
+// etagMatches returns whether the If-None-Match header value matches etag.
+// The wildcard, which matches any existing blob, is not matched.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+
+		if v == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified returns whether the blob with the given hash does not have to
+// be sent to a client which has seen the etag given in If-None-Match
+func (s *Storage) notModified(ifNoneMatch string, hash string, enc *Encoding) (bool, error) {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return s.Exists(hash)
+	}
+
+	return etagMatches(ifNoneMatch, s.ETag(hash, enc)), nil
+}
+
+// upgrade upgrades the blob with the given hash if it is outdated, and returns
+// the hash of the upgraded blob. Blobs are only upgraded once, after which the
+// upgraded blob is recorded as the alias of the outdated blob.
+func (s *Storage) upgrade(hash string) (string, error) {
+	if uhash, err := s.upgradedHash(hash); err != nil || len(uhash) != 0 {
+		return uhash, err
+	}
+
+	data, found, err := s.read(hash, "")
+
+	if err != nil {
+		return "", err
+	}
+
+	// Legacy hashes resolve to the blob which may have been upgraded
+	if found != hash {
+		if uhash, err := s.upgradedHash(found); err != nil || len(uhash) != 0 {
+			return uhash, err
+		}
+	}
+
+	upgraded, outdated, err := s.Upgrade(data)
+
+	if err != nil || !outdated {
+		return hash, err
+	}
+
+	existed, err := s.Exists(hasher.Hash(upgraded))
+
+	if err != nil {
+		return "", err
+	}
+
+	uhash, err := s.Store(upgraded)
+
+	if err != nil {
+		return "", err
+	}
+
+	if s.Upgraded != nil {
+		if err := s.Upgraded(found, uhash, existed); err != nil {
+			return "", err
+		}
+	}
+
+	return uhash, db.AddAlias(s.Directory, found, uhash)
+}
+
+// upgradedHash returns the hash of the upgraded blob of the blob with the
+// given hash, or an empty string if it has not been upgraded
+func (s *Storage) upgradedHash(hash string) (string, error) {
+	// Aliases of legacy hashes refer to the same data, not to an upgrade
+	if hasher.IsLegacyHash(hash) {
+		return "", nil
+	}
+
+	return db.ResolveAlias(s.Directory, hash)
+}
+
+func (s *Storage) Get(writer http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["id"]
 
 	if len(id) <= 2 {
-		http.Error(writer, "404 not found", http.StatusNotFound)
+		s.RespondError(writer, NotFoundError())
 		return
 	}
 
-	h := writer.Header()
+	restricted := false
 
-	if len(s.ContentType) != 0 {
-		h.Set("Content-Type", s.ContentType)
+	if s.Access != nil {
+		var err error
+
+		if restricted, err = s.Access(req, id); err != nil {
+			s.RespondError(writer, err)
+			return
+		}
 	}
 
-	h.Set("Cache-Control", "max-age=31536000")
+	var enc *Encoding
+
+	for i, e := range s.Encodings {
+		if acceptsEncoding(req, e.Name) {
+			enc = &s.Encodings[i]
+			break
+		}
+	}
+
+	// Blobs never change, so a client which has already seen a blob does not
+	// need it to be read again. Legacy hashes are resolved first, since the
+	// etag is always the full strength hash of the blob.
+	if !hasher.IsLegacyHash(id) {
+		notModified, err := s.notModified(req.Header.Get("If-None-Match"), id, enc)
+
+		if err != nil {
+			s.RespondError(writer, err)
+			return
+		}
+
+		if notModified {
+			s.setHeaders(writer.Header(), id, enc, restricted)
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Outdated blobs are stored again after upgrading them, and clients are
+	// redirected to the upgraded blob
+	if s.Upgrade != nil {
+		hash, err := s.upgrade(id)
+
+		if err != nil {
+			s.RespondError(writer, err)
+			return
+		}
+
+		if hash != id {
+			// Keep the query, which may contain the key of the blob
+			u := *req.URL
+			u.Path = strings.Replace(u.Path, id, hash, 1)
+
+			http.Redirect(writer, req, u.String(), http.StatusFound)
+			return
+		}
+	}
+
+	var data []byte
+	var hash string
+	var err error
+
+	if enc != nil {
+		data, hash, err = s.readEncoded(id, *enc)
+	} else {
+		data, hash, err = s.read(id, "")
+	}
+
+	if err != nil {
+		s.RespondError(writer, err)
+		return
+	}
+
+	s.setHeaders(writer.Header(), hash, enc, restricted)
+
+	if s.ContentTypeOf != nil {
+		writer.Header().Set("Content-Type", s.ContentTypeOf(data))
+	}
 
-	http.ServeFile(writer, req, s.HashPath(id))
+	// ServeContent handles the remaining conditional headers, as well as
+	// omitting the body for HEAD requests
+	http.ServeContent(writer, req, "", time.Time{}, bytes.NewReader(data))
 }
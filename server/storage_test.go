@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header  string
+		matches bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"def", "abc"`, true},
+		{`*`, false},
+		{`"def"`, false},
+		{`abc`, false},
+		{``, false},
+	}
+
+	for _, test := range tests {
+		if matches := etagMatches(test.header, `"abc"`); matches != test.matches {
+			t.Errorf("%q: expected matches %v, got %v", test.header, test.matches, matches)
+		}
+	}
+}
+
+func TestStorageConditionalGet(t *testing.T) {
+	setupTest(t)
+
+	hash := storeTestBlob(t, ScreenshotsStorage, string(testPNG(t)))
+	url := "/s/" + hash + ".png"
+
+	rec := serveTest(t, "GET", url, nil, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	etag := rec.Header().Get("ETag")
+
+	if etag != `"`+hash+`"` {
+		t.Errorf("expected strong etag of the hash, got %s", etag)
+	}
+
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=31536000, immutable" {
+		t.Errorf("unexpected cache control %q", cc)
+	}
+
+	tests := []struct {
+		header string
+		value  string
+		code   int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `W/` + etag, http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-None-Match", `*`, http.StatusNotModified},
+		{"If-None-Match", `"` + hash + `-gzip"`, http.StatusOK},
+		{"If-Match", `"other"`, http.StatusPreconditionFailed},
+		{"Range", "bytes=0-3", http.StatusPartialContent},
+	}
+
+	for _, test := range tests {
+		rec := serveTest(t, "GET", url, http.Header{test.header: {test.value}}, nil)
+
+		if rec.Code != test.code {
+			t.Errorf("%s: %s: expected %d, got %d", test.header, test.value, test.code, rec.Code)
+		}
+
+		if test.code == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s: %s: expected empty body", test.header, test.value)
+		}
+	}
+
+	if rec := serveTest(t, "HEAD", url, nil, nil); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("expected empty 200 response to HEAD, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	if rec := serveTest(t, "GET", "/s/"+hasher.Hash([]byte("missing"))+".png", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected missing blob to not be found, got %d", rec.Code)
+	}
+
+	// The wildcard only matches blobs which exist
+	if rec := serveTest(t, "GET", "/s/"+hasher.Hash([]byte("missing"))+".png", http.Header{"If-None-Match": {"*"}}, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected missing blob to not be found with a wildcard, got %d", rec.Code)
+	}
+}
+
+func TestStorageEncodedETag(t *testing.T) {
+	setupTest(t)
+
+	doc := storeTestDocument(t, newTestDocument())
+	url := "/d/" + doc + ".json"
+
+	plain := serveTest(t, "GET", url, nil, nil)
+	gzipped := serveTest(t, "GET", url, http.Header{"Accept-Encoding": {"gzip"}}, nil)
+
+	if plain.Code != http.StatusOK || gzipped.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d and %d", plain.Code, gzipped.Code)
+	}
+
+	if gzipped.Header().Get("Content-Encoding") != "gzip" || plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected only the gzip variant to be encoded")
+	}
+
+	if plain.Header().Get("ETag") == gzipped.Header().Get("ETag") {
+		t.Errorf("expected variants to have different etags")
+	}
+
+	if gzipped.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected response to vary by encoding")
+	}
+
+	rec := serveTest(t, "GET", url, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipped.Header().Get("ETag")}}, nil)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for the etag of the gzip variant, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Views older than this many half-lives weigh less than a millionth of a
+// recent view, and are not counted towards trending scores
+const trendingHorizon = 20
+
+type TrendingOptions struct {
+	HalfLife time.Duration `long:"trending-half-life" description:"Age at which a view counts half towards the trending score of a gallery item" default:"72h"`
+	Interval time.Duration `long:"trending-interval" description:"Interval at which to update the trending scores of gallery items in the background (disabled when 0)" default:"10m"`
+}
+
+// trendingWeight returns the weight of a view of the given age, which halves
+// every half-life
+func trendingWeight(age time.Duration, halfLife time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+
+	return math.Exp2(-age.Seconds() / halfLife.Seconds())
+}
+
+// UpdateTrending updates the trending scores of all published gallery items
+func UpdateTrending(halfLife time.Duration) error {
+	now := time.Now()
+	since := now.Add(-trendingHorizon * halfLife)
+
+	return db.UpdateTrending(since, func(date time.Time) float64 {
+		return trendingWeight(now.Sub(date), halfLife)
+	})
+}
+
+func runTrending(opts TrendingOptions) {
+	for {
+		if err := UpdateTrending(opts.HalfLife); err != nil {
+			log.Printf("Failed to update trending scores: %v", err)
+		}
+
+		time.Sleep(opts.Interval)
+	}
+}
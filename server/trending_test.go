@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrendingWeight(t *testing.T) {
+	halfLife := 72 * time.Hour
+
+	tests := []struct {
+		age      time.Duration
+		expected float64
+	}{
+		{0, 1},
+		{-time.Hour, 1},
+		{halfLife, 0.5},
+		{2 * halfLife, 0.25},
+		{halfLife / 2, math.Sqrt(0.5)},
+		{trendingHorizon * halfLife, math.Exp2(-trendingHorizon)},
+	}
+
+	for _, tt := range tests {
+		if w := trendingWeight(tt.age, halfLife); math.Abs(w-tt.expected) > 1e-9 {
+			t.Errorf("%v: expected %v, got %v", tt.age, tt.expected, w)
+		}
+	}
+
+	if w := trendingWeight(trendingHorizon*halfLife, halfLife); w >= 1e-6 {
+		t.Errorf("expected views at the horizon to weigh less than a millionth, got %v", w)
+	}
+}
+
+// addTestView records a view of the gallery item with the given id
+func addTestView(t *testing.T, id int, ip string, date time.Time) {
+	t.Helper()
+
+	if _, err := db.Exec("INSERT INTO views (id, ip, date) VALUES (?, ?, ?)", id, ip, date); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateTrending(t *testing.T) {
+	setupTest(t)
+
+	halfLife := 72 * time.Hour
+	now := time.Now()
+
+	a := publishTestItem(t, "", "A")
+	b := publishTestItem(t, "", "B")
+	c := publishTestItem(t, "", "C")
+	d := publishTestItem(t, "", "D")
+
+	addTestView(t, a.Id, "1", now)
+	addTestView(t, a.Id, "2", now)
+	addTestView(t, b.Id, "1", now)
+	addTestView(t, b.Id, "2", now.Add(-halfLife))
+	addTestView(t, c.Id, "1", now.Add(-2*trendingHorizon*halfLife))
+
+	// Views recorded before views had a date do not count
+	if _, err := db.Exec("INSERT INTO views (id, ip) VALUES (?, ?)", d.Id, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scores of earlier updates are reset
+	if _, err := db.Exec("UPDATE gallery SET trending = 10 WHERE id = ?", c.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateTrending(halfLife); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "trending"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if titles := galleryTitles(items); titles != "A,B,D,C" {
+		t.Errorf("expected items by trending score, got %s", titles)
+	}
+
+	expected := map[string]float64{"A": 2, "B": 1.5, "C": 0, "D": 0}
+
+	for _, item := range items {
+		if math.Abs(item.Trending-expected[item.Title]) > 1e-3 {
+			t.Errorf("%s: expected score %v, got %v", item.Title, expected[item.Title], item.Trending)
+		}
+	}
+
+	// Revisions keep the views of the items they replace where they are
+	a2 := publishTestItem(t, a.Token, "A2")
+
+	var views int
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM views WHERE id = ?", a.Id).Scan(&views); err != nil || views != 2 {
+		t.Errorf("expected the views of the replaced item to be kept, got %d, %v", views, err)
+	}
+
+	if err := UpdateTrending(halfLife); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only published items have a score, which includes the views of the
+	// revisions they replaced
+	scores := map[int]float64{a.Id: 0, a2.Id: 2}
+
+	for id, expected := range scores {
+		var score float64
+
+		if err := db.QueryRow("SELECT trending FROM gallery WHERE id = ?", id).Scan(&score); err != nil || math.Abs(score-expected) > 1e-3 {
+			t.Errorf("%d: expected score %v, got %v, %v", id, expected, score, err)
+		}
+	}
+
+	// Views of every earlier revision count
+	a3 := publishTestItem(t, a2.Token, "A3")
+
+	addTestView(t, a2.Id, "3", now)
+	addTestView(t, a3.Id, "1", now)
+
+	if err := UpdateTrending(halfLife); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err = db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "trending"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if titles := galleryTitles(items); titles != "A3,B,D,C" {
+		t.Errorf("expected the republished item first, got %s", titles)
+	}
+
+	if math.Abs(items[0].Trending-4) > 1e-3 {
+		t.Errorf("expected the views of all revisions to be scored, got %v", items[0].Trending)
+	}
+}
+
+func TestViewGalleryHandler(t *testing.T) {
+	setupTest(t)
+
+	a := publishTestItem(t, "", "A")
+	url := fmt.Sprintf("/g/0/%d/view", a.Id)
+
+	tests := []struct {
+		ip    string
+		views int
+	}{
+		{"10.0.0.1", 1},
+		{"10.0.0.1", 1},
+		{"10.0.0.2", 2},
+	}
+
+	for _, tt := range tests {
+		serveTest(t, "POST", url, http.Header{"X-Real-Ip": {tt.ip}}, nil)
+
+		var views int
+
+		if err := db.QueryRow("SELECT views FROM gallery WHERE id = ?", a.Id).Scan(&views); err != nil || views != tt.views {
+			t.Errorf("%s: expected %d views, got %d, %v", tt.ip, tt.views, views, err)
+		}
+	}
+
+	// Views have a date to count towards trending scores
+	if err := UpdateTrending(72 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err := db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "trending"})
+
+	if err != nil || len(items) != 1 || math.Abs(items[0].Trending-2) > 1e-3 {
+		t.Errorf("expected recent views to be scored, got %v, %v", items, err)
+	}
+
+	// Views of a revision are counted separately from the item it replaced
+	a2 := publishTestItem(t, a.Token, "A2")
+
+	serveTest(t, "POST", fmt.Sprintf("/g/0/%d/view", a2.Id), http.Header{"X-Real-Ip": {"10.0.0.1"}}, nil)
+
+	var views int
+
+	if err := db.QueryRow("SELECT views FROM gallery WHERE id = ?", a2.Id).Scan(&views); err != nil || views != 3 {
+		t.Errorf("expected the view of the revision to be counted, got %d, %v", views, err)
+	}
+
+	// The revision is scored by the views of both revisions
+	if err := UpdateTrending(72 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err = db.Gallery(GalleryQuery{Limit: DefaultGalleryLimit, Sort: "trending"})
+
+	if err != nil || len(items) != 1 || items[0].Id != a2.Id || math.Abs(items[0].Trending-3) > 1e-3 {
+		t.Errorf("expected the views of both revisions to be scored, got %v, %v", items, err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The current versions of the document and program schemas. Documents are
+// upgraded to these versions whenever they are decoded.
+const DocumentVersion = 1
+const ProgramVersion = 1
+
+// SchemaUpgrade upgrades the JSON representation of a document or program
+// from the version it is registered for to the next version
+type SchemaUpgrade func(v map[string]interface{}) error
+
+// documentUpgrades and programUpgrades contain the upgrade for each version
+// which is older than the current version, keyed by the version they upgrade
+// from
+var documentUpgrades = map[int]SchemaUpgrade{
+	0: upgradeUnversioned,
+}
+
+var programUpgrades = map[int]SchemaUpgrade{
+	0: upgradeUnversioned,
+}
+
+// Documents and programs stored before versioning was introduced do not have
+// a version, but are otherwise the same as version 1
+func upgradeUnversioned(v map[string]interface{}) error {
+	return nil
+}
+
+type versionInfo struct {
+	Version  int `json:"version"`
+	Programs []struct {
+		Version int `json:"version"`
+	} `json:"programs"`
+}
+
+func schemaVersion(v map[string]interface{}) int {
+	if n, ok := v["version"].(float64); ok {
+		return int(n)
+	}
+
+	return 0
+}
+
+func upgradeSchema(v map[string]interface{}, current int, upgrades map[int]SchemaUpgrade, kind string) error {
+	for version := schemaVersion(v); version < current; version++ {
+		upgrade, ok := upgrades[version]
+
+		if !ok {
+			return fmt.Errorf("Cannot upgrade %s from version %d", kind, version)
+		}
+
+		if err := upgrade(v); err != nil {
+			return err
+		}
+
+		v["version"] = version + 1
+	}
+
+	return nil
+}
+
+// UpgradeDocument upgrades the JSON representation of a document, and of all
+// of its programs, to the current schema versions. It returns the upgraded
+// data and whether anything was upgraded. Documents from newer, unknown,
+// versions result in an error.
+func UpgradeDocument(data []byte) ([]byte, bool, error) {
+	var info versionInfo
+
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, false, err
+	}
+
+	outdated := info.Version < DocumentVersion
+
+	if info.Version > DocumentVersion {
+		return nil, false, fmt.Errorf("Unsupported document version %d", info.Version)
+	}
+
+	for _, p := range info.Programs {
+		if p.Version > ProgramVersion {
+			return nil, false, fmt.Errorf("Unsupported program version %d", p.Version)
+		}
+
+		outdated = outdated || p.Version < ProgramVersion
+	}
+
+	if !outdated {
+		return data, false, nil
+	}
+
+	var doc map[string]interface{}
+
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return data, false, err
+	}
+
+	if err := upgradeSchema(doc, DocumentVersion, documentUpgrades, "document"); err != nil {
+		return nil, false, err
+	}
+
+	programs, _ := doc["programs"].([]interface{})
+
+	for _, p := range programs {
+		if program, ok := p.(map[string]interface{}); ok {
+			if err := upgradeSchema(program, ProgramVersion, programUpgrades, "program"); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	upgraded, err := json.Marshal(doc)
+	return upgraded, true, err
+}
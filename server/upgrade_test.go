@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2014 Jesse van den Kieboom. All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are
+ * met:
+ *
+ *    * Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *    * Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following disclaimer
+ *      in the documentation and/or other materials provided with the
+ *      distribution.
+ *    * Neither the name of Google Inc. nor the names of its
+ *      contributors may be used to endorse or promote products derived from
+ *      this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+ * A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+ * OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+ * LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+ * DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+ * THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeDocument(t *testing.T) {
+	tests := []struct {
+		data     string
+		upgraded bool
+		err      bool
+	}{
+		{`{"version":1,"programs":[{"version":1}]}`, false, false},
+		{`{"programs":[{"version":1}]}`, true, false},
+		{`{"version":1,"programs":[{}]}`, true, false},
+		{`{}`, true, false},
+		{`{"version":2,"programs":[]}`, false, true},
+		{`{"version":1,"programs":[{"version":2}]}`, false, true},
+		{`not json`, false, true},
+	}
+
+	for _, test := range tests {
+		data, upgraded, err := UpgradeDocument([]byte(test.data))
+
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.data, test.err, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if upgraded != test.upgraded {
+			t.Errorf("%s: expected upgraded %v, got %v", test.data, test.upgraded, upgraded)
+		}
+
+		var info versionInfo
+
+		if err := json.Unmarshal(data, &info); err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Version != DocumentVersion {
+			t.Errorf("%s: expected version %d, got %d", test.data, DocumentVersion, info.Version)
+		}
+
+		for _, p := range info.Programs {
+			if p.Version != ProgramVersion {
+				t.Errorf("%s: expected program version %d, got %d", test.data, ProgramVersion, p.Version)
+			}
+		}
+	}
+}
+
+// unversionedTestDocument stores a document as it was stored before documents
+// had a schema version
+func unversionedTestDocument(t *testing.T) string {
+	t.Helper()
+
+	data, err := json.Marshal(newTestDocument())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]interface{}
+	json.Unmarshal(data, &v)
+
+	delete(v, "version")
+
+	for _, p := range v["programs"].([]interface{}) {
+		delete(p.(map[string]interface{}), "version")
+	}
+
+	data, _ = json.Marshal(v)
+	return storeTestBlob(t, DocumentStorage, string(data))
+}
+
+func TestStorageUpgradeOnce(t *testing.T) {
+	setupTest(t)
+
+	hash := unversionedTestDocument(t)
+	share, _ := NewDocumentShare(VisibilityUnlisted, nil)
+
+	if err := db.AddDocumentShare(hash, share, false); err != nil {
+		t.Fatal(err)
+	}
+
+	upgrades := 0
+	upgrade := DocumentStorage.Upgrade
+
+	DocumentStorage.Upgrade = func(data []byte) ([]byte, bool, error) {
+		upgrades++
+		return upgrade(data)
+	}
+
+	defer func() { DocumentStorage.Upgrade = upgrade }()
+
+	var location string
+
+	for i := 0; i < 3; i++ {
+		rec := serveTest(t, "GET", "/d/"+hash+".json", nil, nil)
+
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected outdated document to redirect, got %d", rec.Code)
+		}
+
+		if i != 0 && rec.Header().Get("Location") != location {
+			t.Errorf("expected the same redirect, got %s and %s", location, rec.Header().Get("Location"))
+		}
+
+		location = rec.Header().Get("Location")
+	}
+
+	if upgrades != 1 {
+		t.Errorf("expected the document to be upgraded once, got %d", upgrades)
+	}
+
+	uhash := strings.TrimSuffix(strings.TrimPrefix(location, "/d/"), ".json")
+
+	if shares, err := db.DocumentShares(uhash); err != nil || len(shares) != 1 || shares[0] != share {
+		t.Errorf("expected the share to be copied once, got %v, %v", shares, err)
+	}
+
+	if rec := serveTest(t, "GET", location, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("expected upgraded document to be served, got %d", rec.Code)
+	}
+
+	// Copying shares again does not duplicate them
+	if err := db.CopyDocumentShares(hash, uhash, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if shares, _ := db.DocumentShares(uhash); len(shares) != 1 {
+		t.Errorf("expected shares to not be duplicated, got %d", len(shares))
+	}
+}